@@ -0,0 +1,452 @@
+package bitsplit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Encrypted files are cut into segments sealed one by one (the STREAM construction),
+// so they are encrypted and decrypted in constant memory. All numbers are big endian
+//   header:   magic "BSEC", format version, algorithm id, segment size (uint32), key derivation parameters
+//             if the key comes from a passphrase, key fingerprint, random nonce prefix
+//   segments: segment size bytes of plaintext each, sealed with nonce prefix || counter (uint32) || last flag
+//             and the header followed by the caller's associated data as additional data
+// Only the last segment has the last flag set, it is shorter than the others or empty.
+// Reordered, dropped or truncated segments fail authentication, a file cut off right after a segment
+// is reported as truncated
+
+const (
+	EncryptedFormatVersion = 1
+
+	segmentSize        = 64 * 1024
+	maxSegmentSize     = 16 * 1024 * 1024
+	keyFingerprintSize = 8
+)
+
+var encryptedMagic = []byte("BSEC")
+
+// nonce prefix || counter || last flag
+func setSegmentNonce(nonce []byte, counter uint32, last bool) {
+	binary.BigEndian.PutUint32(nonce[len(nonce)-5:], counter)
+	nonce[len(nonce)-1] = 0
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+}
+
+// reports whether the reader has nothing left
+func atEOF(r *bufio.Reader) (bool, error) {
+	_, err := r.Peek(1)
+	if err == io.EOF {
+		return true, nil
+	}
+	return false, err
+}
+
+// identifies the key without revealing it, so a wrong key is reported before decrypting anything
+func keyFingerprint(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("bitsplit key fingerprint"))
+	return mac.Sum(nil)[:keyFingerprintSize]
+}
+
+// hex fingerprint of the key as stored in the header of encrypted files
+func KeyFingerprint(key []byte) string {
+	return hex.EncodeToString(keyFingerprint(key))
+}
+
+type encryptedHeader struct {
+	raw         []byte
+	cipher      aeadCipher
+	segmentSize uint32
+	kdf         KDFParams
+	fingerprint []byte
+	noncePrefix []byte
+}
+
+func newEncryptedHeader(random RandomSource, c aeadCipher, nonceSize int, kdf KDFParams, key []byte) (encryptedHeader, error) {
+	h := encryptedHeader{
+		cipher:      c,
+		segmentSize: segmentSize,
+		kdf:         kdf,
+		fingerprint: keyFingerprint(key),
+		noncePrefix: make([]byte, nonceSize-5),
+	}
+	err := readRandom(random, h.noncePrefix)
+	if err != nil {
+		return h, err
+	}
+
+	h.raw = make([]byte, 10, 10+1+9+kdfSaltSize+keyFingerprintSize+len(h.noncePrefix))
+	copy(h.raw, encryptedMagic)
+	h.raw[4] = EncryptedFormatVersion
+	h.raw[5] = c.algorithm()
+	binary.BigEndian.PutUint32(h.raw[6:], h.segmentSize)
+	h.raw = append(h.raw, kdf.marshal()...)
+	h.raw = append(h.raw, h.fingerprint...)
+	h.raw = append(h.raw, h.noncePrefix...)
+	return h, nil
+}
+
+func readEncryptedHeader(r io.Reader) (encryptedHeader, error) {
+	var h encryptedHeader
+	truncated := func(err error) error {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errorf(ErrShortCiphertext, "encrypted file is truncated")
+		}
+		return IOError{"while reading the file", err}
+	}
+
+	fixed := make([]byte, 10)
+	_, err := io.ReadFull(r, fixed)
+	if err != nil {
+		return h, truncated(err)
+	}
+	if !bytes.Equal(fixed[:4], encryptedMagic) {
+		return h, fmt.Errorf("file is not encrypted in segments")
+	}
+	if fixed[4] != EncryptedFormatVersion {
+		return h, fmt.Errorf("unsupported encrypted format version %d", fixed[4])
+	}
+	h.cipher, err = cipherByAlgorithm(fixed[5])
+	if err != nil {
+		return h, err
+	}
+	h.segmentSize = binary.BigEndian.Uint32(fixed[6:])
+	if h.segmentSize == 0 || h.segmentSize > maxSegmentSize {
+		return h, fmt.Errorf("invalid segment size %d", h.segmentSize)
+	}
+
+	var kdf []byte
+	h.kdf, kdf, err = readKDFParams(r)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return h, truncated(err)
+	}
+	if err != nil {
+		return h, err
+	}
+
+	// the nonce size doesn't depend on the key
+	aead, err := h.cipher.newAEAD(make([]byte, h.cipher.KeySize()))
+	if err != nil {
+		return h, err
+	}
+	rest := make([]byte, keyFingerprintSize+aead.NonceSize()-5)
+	_, err = io.ReadFull(r, rest)
+	if err != nil {
+		return h, truncated(err)
+	}
+	h.fingerprint, h.noncePrefix = rest[:keyFingerprintSize], rest[keyFingerprintSize:]
+	h.raw = append(append(fixed, kdf...), rest...)
+	return h, nil
+}
+
+// checks that the file is encrypted with the cipher c, any cipher if it is nil
+func (h encryptedHeader) checkCipher(c aeadCipher) error {
+	if c != nil && c.algorithm() != h.cipher.algorithm() {
+		return fmt.Errorf("file is encrypted with %s, not %s", h.cipher.Name(), c.Name())
+	}
+	return nil
+}
+
+// creates the AEAD of the file, refusing keys with a different fingerprint
+func (h encryptedHeader) openAEAD(key []byte) (cipher.AEAD, error) {
+	aead, err := h.cipher.newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if fingerprint := keyFingerprint(key); !bytes.Equal(fingerprint, h.fingerprint) {
+		return nil, errorf(ErrAuthenticationFailed, "this file was encrypted with key %x, you supplied %x", h.fingerprint, fingerprint)
+	}
+	return aead, nil
+}
+
+// the header and ad are authenticated with every segment
+func segmentAdditionalData(header, ad []byte) []byte {
+	return append(header[:len(header):len(header)], ad...)
+}
+
+func encryptStream(random RandomSource, c aeadCipher, key []byte, kdf KDFParams, ad []byte,
+	file io.Reader, output io.Writer) error {
+	aead, err := c.newAEAD(key)
+	if err != nil {
+		return err
+	}
+	h, err := newEncryptedHeader(random, c, aead.NonceSize(), kdf, key)
+	if err != nil {
+		return err
+	}
+	_, err = output.Write(h.raw)
+	if err != nil {
+		return IOError{"while writing encrypted data", err}
+	}
+
+	additional := segmentAdditionalData(h.raw, ad)
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, h.noncePrefix)
+	in := bufio.NewReader(file)
+	plain := make([]byte, segmentSize)
+	sealed := make([]byte, 0, segmentSize+aead.Overhead())
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(in, plain)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return IOError{"while reading contents of encrypted file", err}
+		}
+		last := n < segmentSize
+		if !last {
+			last, err = atEOF(in)
+			if err != nil {
+				return IOError{"while reading contents of encrypted file", err}
+			}
+		}
+		if !last && counter == math.MaxUint32 {
+			return fmt.Errorf("file is too big to encrypt")
+		}
+
+		setSegmentNonce(nonce, counter, last)
+		sealed = aead.Seal(sealed[:0], nonce, plain[:n], additional)
+		_, err = output.Write(sealed)
+		if err != nil {
+			return IOError{"while writing encrypted data", err}
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// checks if the file starts with the header of the segmented format
+func isSegmented(in *bufio.Reader) (bool, error) {
+	magic, err := in.Peek(len(encryptedMagic))
+	if err != nil && err != io.EOF {
+		return false, IOError{"while reading the file", err}
+	}
+	return bytes.Equal(magic, encryptedMagic), nil
+}
+
+// decrypts a file encrypted with the cipher c, or with any cipher if c is nil
+func decryptStream(c aeadCipher, key, ad []byte, in *bufio.Reader, output io.Writer) error {
+	h, err := readEncryptedHeader(in)
+	if err != nil {
+		return err
+	}
+	err = h.checkCipher(c)
+	if err != nil {
+		return err
+	}
+	if h.kdf.KDF != KDFNone {
+		return fmt.Errorf("file is encrypted with a passphrase, not a key")
+	}
+	aead, err := h.openAEAD(key)
+	if err != nil {
+		return err
+	}
+	return decryptSegments(aead, h, ad, in, output)
+}
+
+func decryptSegments(aead cipher.AEAD, h encryptedHeader, ad []byte, in *bufio.Reader, output io.Writer) error {
+	additional, size := segmentAdditionalData(h.raw, ad), h.segmentSize
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, h.noncePrefix)
+	sealed := make([]byte, int(size)+aead.Overhead())
+	plain := make([]byte, 0, size)
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(in, sealed)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return IOError{"while reading the file", err}
+		}
+		if n < aead.Overhead() {
+			return errorf(ErrShortCiphertext, "encrypted file is truncated")
+		}
+		last := n < len(sealed)
+		if !last {
+			last, err = atEOF(in)
+			if err != nil {
+				return IOError{"while reading the file", err}
+			}
+		}
+		if !last && counter == math.MaxUint32 {
+			return fmt.Errorf("encrypted file has too many segments")
+		}
+
+		setSegmentNonce(nonce, counter, last)
+		plain, err = aead.Open(plain[:0], nonce, sealed[:n], additional)
+		if err != nil && last && n == len(sealed) {
+			// a full segment with the segments after it cut off
+			setSegmentNonce(nonce, counter, false)
+			_, err = aead.Open(plain[:0], nonce, sealed[:n], additional)
+			if err == nil {
+				return errorf(ErrShortCiphertext, "encrypted file is truncated after segment %d", counter)
+			}
+		}
+		if err != nil {
+			return IOError{fmt.Sprintf("while decrypting segment %d", counter), ErrAuthenticationFailed}
+		}
+		_, err = output.Write(plain)
+		if err != nil {
+			return IOError{"while writing output", err}
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// decrypts n bytes of plaintext starting at off, only the segments covering them are read.
+// If the range goes past the end of the file, the bytes up to the end are returned with io.EOF
+func decryptRange(key, ad []byte, r io.ReaderAt, off, n int64) ([]byte, error) {
+	if off < 0 || n < 0 {
+		return nil, fmt.Errorf("invalid range %d+%d", off, n)
+	}
+
+	h, err := readEncryptedHeader(io.NewSectionReader(r, 0, math.MaxInt64))
+	if err != nil {
+		return nil, err
+	}
+	if h.kdf.KDF != KDFNone {
+		return nil, fmt.Errorf("file is encrypted with a passphrase, not a key")
+	}
+	aead, err := h.openAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	additional, size := segmentAdditionalData(h.raw, ad), int64(h.segmentSize)
+
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, h.noncePrefix)
+	sealedSize := size + int64(aead.Overhead())
+	// one more byte to see if another segment follows
+	sealed := make([]byte, sealedSize+1)
+	plain := make([]byte, 0, size)
+
+	// reads the segment into sealed, returns the number of bytes read
+	readSegment := func(segment int64) (int, error) {
+		m, err := r.ReadAt(sealed, int64(len(h.raw))+segment*sealedSize)
+		if err != nil && err != io.EOF {
+			return 0, IOError{"while reading the file", err}
+		}
+		return m, nil
+	}
+	// opens the segment read into sealed, returns if it is the last one
+	openSegment := func(segment int64, m int) (bool, error) {
+		if m < aead.Overhead() {
+			return false, errorf(ErrShortCiphertext, "encrypted file is truncated")
+		}
+		last := m <= int(sealedSize)
+		if !last {
+			m = int(sealedSize)
+		}
+		setSegmentNonce(nonce, uint32(segment), last)
+		var err error
+		plain, err = aead.Open(plain[:0], nonce, sealed[:m], additional)
+		if err != nil && last && m == int(sealedSize) {
+			// a full segment with the segments after it cut off
+			last = false
+			setSegmentNonce(nonce, uint32(segment), last)
+			plain, err = aead.Open(plain[:0], nonce, sealed[:m], additional)
+		}
+		if err != nil {
+			return false, IOError{fmt.Sprintf("while decrypting segment %d", segment), ErrAuthenticationFailed}
+		}
+		return last, nil
+	}
+	// the range starts past the end of the file, it ends with a segment that has the last flag
+	// or it is truncated
+	checkEnd := func(segment int64) error {
+		// the first missing segment, found by bisection
+		end, missing := int64(0), segment
+		for end < missing {
+			mid := end + (missing-end)/2
+			m, err := readSegment(mid)
+			if err != nil {
+				return err
+			}
+			if m == 0 {
+				missing = mid
+			} else {
+				end = mid + 1
+			}
+		}
+		if end == 0 {
+			return errorf(ErrShortCiphertext, "encrypted file is truncated")
+		}
+		m, err := readSegment(end - 1)
+		if err != nil {
+			return err
+		}
+		last, err := openSegment(end-1, m)
+		if err != nil {
+			return err
+		}
+		if !last {
+			return errorf(ErrShortCiphertext, "encrypted file is truncated")
+		}
+		return nil
+	}
+
+	if n == 0 {
+		return []byte{}, nil
+	}
+	// no file holds more than 2^32 segments
+	maxSegments := int64(math.MaxUint32) + 1
+	if off >= maxSegments*size {
+		err := checkEnd(maxSegments)
+		if err != nil {
+			return nil, err
+		}
+		return []byte{}, io.EOF
+	}
+	if n > maxSegments*size-off {
+		n = maxSegments*size - off
+	}
+	capacity := n
+	if capacity > size {
+		capacity = size
+	}
+	result := make([]byte, 0, capacity)
+	for segment := off / size; segment <= (off+n-1)/size; segment++ {
+		m, err := readSegment(segment)
+		if err != nil {
+			return nil, err
+		}
+		if m == 0 && segment > 0 {
+			err := checkEnd(segment)
+			if err != nil {
+				return nil, err
+			}
+			return result, io.EOF
+		}
+		last, err := openSegment(segment, m)
+		if err != nil {
+			return nil, err
+		}
+
+		start := segment * size
+		from, to := off-start, off+n-start
+		if from < 0 {
+			from = 0
+		}
+		if to > int64(len(plain)) {
+			to = int64(len(plain))
+		}
+		if from < to {
+			result = append(result, plain[from:to]...)
+		}
+		if last {
+			break
+		}
+	}
+
+	if int64(len(result)) < n {
+		return result, io.EOF
+	}
+	return result, nil
+}
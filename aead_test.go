@@ -0,0 +1,221 @@
+package bitsplit
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"testing"
+)
+
+var testKey = bytes.Repeat([]byte{7}, 32)
+
+func encryptToBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	err := AesGCMEncrypt(bytes.NewReader(data), &b, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestAesGCMRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, segmentSize - 1, segmentSize, segmentSize + 1, 2 * segmentSize} {
+		data := randomBytes(size)
+		var out bytes.Buffer
+		err := AesGCMDecrypt(bytes.NewReader(encryptToBytes(t, data)), &out, testKey)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(out.Bytes(), data) {
+			t.Fatalf("size %d: decrypted data differs", size)
+		}
+	}
+}
+
+func TestAesGCMTampering(t *testing.T) {
+	const sealedSize = segmentSize + 16
+	encrypted := encryptToBytes(t, randomBytes(2*segmentSize+100))
+	headerSize := len(encrypted) - 2*sealedSize - 116
+	segment := func(i int) []byte {
+		end := headerSize + (i+1)*sealedSize
+		if end > len(encrypted) {
+			end = len(encrypted)
+		}
+		return encrypted[headerSize+i*sealedSize : end]
+	}
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+	flipped := append([]byte(nil), encrypted...)
+	flipped[headerSize+sealedSize+10] ^= 1
+	header := encrypted[:headerSize]
+
+	tests := []struct {
+		name      string
+		encrypted []byte
+		key       []byte
+	}{
+		{"wrong key", encrypted, bytes.Repeat([]byte{8}, 32)},
+		{"flipped bit", flipped, testKey},
+		{"swapped segments", join(header, segment(1), segment(0), segment(2)), testKey},
+		{"dropped last segment", join(header, segment(0), segment(1)), testKey},
+		{"dropped middle segment", join(header, segment(0), segment(2)), testKey},
+		{"truncated", encrypted[:len(encrypted)-1], testKey},
+		{"appended", join(encrypted, []byte{0}), testKey},
+		{"header only", header, testKey},
+	}
+	for _, test := range tests {
+		err := AesGCMDecrypt(bytes.NewReader(test.encrypted), io.Discard, test.key)
+		if err == nil {
+			t.Errorf("%s: decrypted", test.name)
+		}
+	}
+
+	// a file cut off after a segment is reported as truncated, not as altered
+	for _, name := range CipherNames() {
+		c, _ := GetCipher(name)
+		var b bytes.Buffer
+		err := c.Encrypt(nil, bytes.NewReader(randomBytes(2*segmentSize+100)), &b, testKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encrypted := b.Bytes()
+		headerSize := len(encrypted) - 2*sealedSize - 116
+		for _, end := range []int{headerSize + sealedSize, headerSize + 2*sealedSize} {
+			err = Decrypt(bytes.NewReader(encrypted[:end]), io.Discard, testKey)
+			if !errors.Is(err, ErrShortCiphertext) {
+				t.Errorf("%s cut after %d bytes: got %v, want ErrShortCiphertext", name, end, err)
+			}
+		}
+		err = Decrypt(bytes.NewReader(encrypted[:headerSize+sealedSize+100]), io.Discard, testKey)
+		if !errors.Is(err, ErrAuthenticationFailed) {
+			t.Errorf("%s cut inside a segment: got %v, want ErrAuthenticationFailed", name, err)
+		}
+	}
+}
+
+func TestAesGCMDecryptOldFormat(t *testing.T) {
+	// nonce || ciphertext of the whole file
+	aead, err := newAesGCM(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := randomBytes(1000)
+	nonce := randomBytes(aead.NonceSize())
+	encrypted := aead.Seal(append([]byte(nil), nonce...), nonce, data, nil)
+
+	var out bytes.Buffer
+	err = AesGCMDecrypt(bytes.NewReader(encrypted), &out, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("decrypted data differs")
+	}
+}
+
+func TestDecryptRange(t *testing.T) {
+	data := randomBytes(3*segmentSize + 10)
+	encrypted := bytes.NewReader(encryptToBytes(t, data))
+	size := int64(len(data))
+	tests := []struct{ off, n int64 }{
+		{0, 0}, {0, 1}, {0, size}, {5, 100},
+		{segmentSize - 1, 2}, {segmentSize, segmentSize}, {segmentSize - 10, segmentSize + 20},
+		{size - 1, 1}, {2 * segmentSize, segmentSize + 10},
+	}
+	for _, test := range tests {
+		got, err := DecryptRange(encrypted, testKey, test.off, test.n)
+		if err != nil {
+			t.Fatalf("%d+%d: %v", test.off, test.n, err)
+		}
+		if !bytes.Equal(got, data[test.off:test.off+test.n]) {
+			t.Fatalf("%d+%d: decrypted data differs", test.off, test.n)
+		}
+	}
+
+	// ranges going past the end return what there is and io.EOF
+	for _, off := range []int64{size - 5, size, size + 1, 10 * segmentSize} {
+		got, err := DecryptRange(encrypted, testKey, off, 10)
+		if err != io.EOF {
+			t.Fatalf("%d+10: got error %v, want io.EOF", off, err)
+		}
+		want := []byte{}
+		if off < size {
+			want = data[off:]
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("%d+10: decrypted data differs", off)
+		}
+	}
+
+	if _, err := DecryptRange(encrypted, testKey, -1, 10); err == nil {
+		t.Fatal("negative offset is decrypted")
+	}
+}
+
+func TestDecryptRangeTampering(t *testing.T) {
+	encrypted := encryptToBytes(t, randomBytes(2*segmentSize))
+	encrypted[len(encrypted)-20] ^= 1
+	// the first segment is still fine, the second one isn't
+	if _, err := DecryptRange(bytes.NewReader(encrypted), testKey, 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecryptRange(bytes.NewReader(encrypted), testKey, segmentSize+5, 10); err == nil {
+		t.Fatal("damaged segment is decrypted")
+	}
+	if _, err := DecryptRange(bytes.NewReader(randomBytes(100)), testKey, 0, 10); err == nil {
+		t.Fatal("file without the segmented format is decrypted")
+	}
+}
+
+func TestDecryptRangeLimits(t *testing.T) {
+	data := randomBytes(2*segmentSize + 10)
+	encrypted := encryptToBytes(t, data)
+	got, err := DecryptRange(bytes.NewReader(encrypted), testKey, 5, math.MaxInt64)
+	if err != io.EOF || !bytes.Equal(got, data[5:]) {
+		t.Fatalf("5+MaxInt64: got %d bytes, %v", len(got), err)
+	}
+	got, err = DecryptRange(bytes.NewReader(encrypted), testKey, math.MaxInt64-10, 10)
+	if err != io.EOF || len(got) != 0 {
+		t.Fatalf("offset past the last segment: got %d bytes, %v", len(got), err)
+	}
+
+	// the last segment is cut off, the one before it is not marked as the last
+	truncated := encrypted[:len(encrypted)-10-16]
+	for _, test := range []struct{ off, n int64 }{
+		{2*segmentSize - 5, 10}, {2 * segmentSize, 10}, {3 * segmentSize, 10}, {0, math.MaxInt64},
+	} {
+		_, err := DecryptRange(bytes.NewReader(truncated), testKey, test.off, test.n)
+		if !errors.Is(err, ErrShortCiphertext) {
+			t.Fatalf("%d+%d of truncated file: got %v, want ErrShortCiphertext", test.off, test.n, err)
+		}
+	}
+	if _, err := DecryptRange(bytes.NewReader(truncated), testKey, 0, 10); err != nil {
+		t.Fatalf("first segment of truncated file: %v", err)
+	}
+}
+
+func TestAesGCMAdditionalData(t *testing.T) {
+	data := randomBytes(segmentSize + 1)
+	var encrypted bytes.Buffer
+	err := AesGCMEncryptWithAD(nil, bytes.NewReader(data), &encrypted, testKey, []byte("dir/a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	err = AesGCMDecryptWithAD(bytes.NewReader(encrypted.Bytes()), &out, testKey, []byte("dir/a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("decrypted data differs")
+	}
+	for _, ad := range [][]byte{nil, []byte("dir/b.txt")} {
+		err = AesGCMDecryptWithAD(bytes.NewReader(encrypted.Bytes()), io.Discard, testKey, ad)
+		if err == nil {
+			t.Fatalf("decrypted with additional data %q", ad)
+		}
+	}
+}
@@ -1,13 +1,15 @@
 package bitsplit
 
 import (
+	"bufio"
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
-	"math/rand"
 	"net/http"
 	"os"
 	"strconv"
@@ -29,6 +31,9 @@ type IOError struct {
 func (err IOError) Error() string {
 	return err.Details + "\n" + err.Contents.Error()
 }
+func (err IOError) Unwrap() error {
+	return err.Contents
+}
 
 type OSError struct {
 	Details string
@@ -37,6 +42,52 @@ type OSError struct {
 func (err OSError) Error() string {
 	return err.Details + "\n" + err.Contents.Error()
 }
+func (err OSError) Unwrap() error {
+	return err.Contents
+}
+
+// errors returned by the package match these with errors.Is
+var (
+	// less than 2 key files, less than the threshold of the split or not enough to meet the policy
+	ErrTooFewShares = errors.New("too few key files")
+	// keys of different splits, repeated keys or keys of another kind than expected
+	ErrShareMismatch = errors.New("key files don't belong together")
+	// truncated key files or ones damaged beyond repair, or shares altered so they don't fit the others
+	ErrShareDamaged = errors.New("key file is truncated or damaged")
+	// the joined data doesn't match the digest stored in the keys
+	ErrReconstructionMismatch = errors.New("joined data doesn't match the digest of the original, keys are altered or damaged")
+	// wrong key or passphrase, or the encrypted file or its associated data are altered
+	ErrAuthenticationFailed = errors.New("message authentication failed")
+	ErrShortCiphertext = errors.New("encrypted file is truncated")
+	ErrBadKeySize = errors.New("invalid key size")
+
+	errLessThanTwoKeys = errorf(ErrTooFewShares, "less than 2 key files provided")
+)
+
+// keeps its own message, but errors.Is matches it with the sentinel.
+// The error it was made of, if any, stays in the chain for errors.As
+type sentinelError struct {
+	sentinel error
+	message  string
+	cause    error
+}
+func (err sentinelError) Error() string {
+	return err.message
+}
+func (err sentinelError) Is(target error) bool {
+	return target == err.sentinel
+}
+func (err sentinelError) Unwrap() error {
+	return err.cause
+}
+
+func errorf(sentinel error, format string, args ...interface{}) error {
+	return sentinelError{sentinel, fmt.Sprintf(format, args...), nil}
+}
+
+func withSentinel(sentinel, err error) error {
+	return sentinelError{sentinel, err.Error(), err}
+}
 
 //---- operations on byte arrays ----
 func Add(a, b []byte) []byte {
@@ -89,6 +140,8 @@ func Neg(a []byte) []byte {
 }
 
 //---- useful functions ----
+
+// Deprecated: the package doesn't use math/rand anymore, see RandomSource
 func GetSeed() int64 {
 	seed := time.Now().UTC().UnixNano()
 
@@ -123,119 +176,238 @@ func GetSeed() int64 {
 	return seed
 }
 
-func Split(file io.Reader, keys []io.Writer) error {
-	data, err := ioutil.ReadAll(file)
-	if err != nil {
-		return IOError{"while reading file contents", err}
+// shares add up to the secret mod 256, every share is required
+type additiveScheme struct{}
+
+func (additiveScheme) ID() SchemeID { return SchemeAdditive }
+func (additiveScheme) Name() string { return "additive" }
+func (additiveScheme) CheckParams(n, k int) error {
+	if k != n {
+		return fmt.Errorf("additive splitting requires all %d keys, threshold %d is not supported", n, k)
 	}
+	return nil
+}
 
-	randoms := make([]byte, len(data))
-	for _, writer := range keys[1:] {
-		rand.Read(randoms)
-		_, err := writer.Write(Neg(randoms))
+func (additiveScheme) Split(random RandomSource, secret []byte, shares [][]byte, k int) error {
+	copy(shares[0], secret)
+	for _, s := range shares[1:] {
+		err := readRandom(random, s)
 		if err != nil {
-			return IOError{"while writing keys", err}
+			return err
+		}
+		for j, b := range s {
+			shares[0][j] -= b
 		}
-		data = Add(data, randoms)
-	}
-	_, err = keys[0].Write(data)
-	if err != nil {
-		return IOError{"while writing keys", err}
 	}
 	return nil
 }
 
+func (additiveScheme) Combine(shares [][]byte, indices []uint16, secret []byte) {
+	copy(secret, shares[0])
+	for _, s := range shares[1:] {
+		for j, b := range s {
+			secret[j] += b
+		}
+	}
+}
+
+// splits with randomness from crypto/rand
+func Split(file io.Reader, keys []io.Writer) error {
+	return SplitWithRandom(nil, file, keys)
+}
+
+func SplitWithRandom(random RandomSource, file io.Reader, keys []io.Writer) error {
+	return splitScheme(additiveScheme{}, random, file, keys, len(keys), 0)
+}
+
 func SplitIntoFiles(file io.Reader, keys []*os.File) error {
+	return SplitIntoFilesWithRandom(nil, file, keys)
+}
+
+func SplitIntoFilesWithRandom(random RandomSource, file io.Reader, keys []*os.File) error {
 	keyWriters := make([]io.Writer, len(keys))
 	for i, key := range keys {
 		keyWriters[i] = key
 	}
-	return Split(file, keyWriters)
+	return SplitWithRandom(random, file, keyWriters)
+}
+
+// splits everything written to it like Split, the keys are complete once it is closed.
+// Errors, also the one of less than 2 shares, are returned by Write and Close
+func NewSplitWriter(shares ...io.Writer) io.WriteCloser {
+	w, err := newSchemeSplitWriter(additiveScheme{}, nil, shares, len(shares), 0)
+	if err != nil {
+		return &splitWriter{err: err}
+	}
+	return w
 }
 
+// joins keys made by Split or SplitThreshold, key files without a header are summed up as before.
+// The data is written as it is joined and checked against the digest in the keys only at the end,
+// so what was written can be trusted only if Join returns nil. JoinFromFiles checks it before writing
 func Join(file io.Writer, keys []io.Reader) error {
 	l := len(keys)
 	if l < 2 {
-		return fmt.Errorf("less than 2 key files provided")
+		return errLessThanTwoKeys
 	}
-    contents := make([][]byte, l)
-    var err error
+	return joinStream(file, keys, 0, 0)
+}
 
-    for i, key := range keys {
-    	contents[i], err = ioutil.ReadAll(key)
-    	if err != nil {
-    		return IOError{"while reading key", err}
-		}
+// returns a *JoinReader, an io.ReadCloser joining the keys like Join as it is read. The joined data
+// is checked against the digest in the keys at the end, so it is trusted only if Read returns io.EOF.
+// The keys are joined in another goroutine that waits for the data to be read, Close stops it
+// if the reader isn't read till the end or an error
+func NewJoinReader(shares ...io.Reader) *JoinReader {
+	return &JoinReader{keys: shares}
+}
+
+// joins like Join, but instead of warning about them returns the altered shares left out
+// and the number of bytes corrected with the parity of every key
+func JoinWithReport(file io.Writer, keys []io.Reader) (JoinReport, error) {
+	if len(keys) < 2 {
+		return JoinReport{}, errLessThanTwoKeys
 	}
+	return joinShares(file, keys, 0, 0)
+}
 
-	_, err = file.Write(Sum(contents))
-	if err != nil {
-		return IOError{"while writing sum", err}
+func JoinWithReportFromFiles(file io.Writer, keys []*os.File) (JoinReport, error) {
+	keyReaders := make([]io.Reader, len(keys))
+	for i, key := range keys {
+		keyReaders[i] = key
 	}
-	return nil
+	return JoinWithReport(file, keyReaders)
 }
 
+// the keys are joined twice, first only to check the result against the digest in the keys,
+// so a wrong file is never written. Keys that can't be rewound, like pipes, are joined once
 func JoinFromFiles(file io.Writer, keys []*os.File) error {
-	keyWriters := make([]io.Reader, len(keys))
+	return JoinFromFilesContext(context.Background(), file, keys, nil)
+}
+
+// JoinFromFiles that can be cancelled, the progress counts the bytes of the keys read in both passes
+func JoinFromFilesContext(ctx context.Context, file io.Writer, keys []*os.File, progress Progress) error {
+	keyReaders := make([]io.Reader, len(keys))
 	for i, key := range keys {
-		keyWriters[i] = key
+		keyReaders[i] = key
+	}
+	size := readersSize(keyReaders)
+	if len(keys) < 2 {
+		return JoinContext(ctx, file, keyReaders, progress)
+	}
+	offsets := make([]int64, len(keys))
+	for i, key := range keys {
+		var err error
+		offsets[i], err = key.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return JoinContext(ctx, file, keyReaders, progress)
+		}
+	}
+	rewind := func() error {
+		for i, key := range keys {
+			_, err := key.Seek(offsets[i], io.SeekStart)
+			if err != nil {
+				return IOError{"while rewinding key", err}
+			}
+		}
+		return nil
 	}
-	return Join(file, keyWriters)
-}
 
-func AesGCMEncrypt(file io.Reader, output io.Writer, key []byte) error {
-	fileContents, err := ioutil.ReadAll(file)
+	// key files without a header have no digest to check
+	first, err := newShareReader(keys[0])
+	checked := err == nil && !first.legacy
+	if checked && size > 0 {
+		size *= 2
+	}
+	counter := newProgressCounter(ctx, size, progress)
+	for i, key := range keys {
+		keyReaders[i] = counter.reader(key)
+	}
+	if checked {
+		err = rewind()
+		if err != nil {
+			return err
+		}
+		_, err = joinShares(ioutil.Discard, keyReaders, 0, 0)
+		if err != nil {
+			return contextError(ctx, err)
+		}
+	}
+	err = rewind()
 	if err != nil {
-		return IOError{"while reading contents of encrypted file", err}
+		return err
 	}
+	return contextError(ctx, Join(file, keyReaders))
+}
 
+func newAesGCM(key []byte) (cipher.AEAD, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		return IOError{"while creating cipher block", err}
+		return nil, IOError{"while creating cipher block", withSentinel(ErrBadKeySize, err)}
 	}
 
 	aesGCM, err := cipher.NewGCM(block)
 	if err != nil {
-		return IOError{"while creating gcm encryption", err}
+		return nil, IOError{"while creating gcm encryption", err}
 	}
+	return aesGCM, nil
+}
 
-	nonce := make([]byte, aesGCM.NonceSize())
-	rand.Read(nonce)
+// encrypts with a nonce prefix from crypto/rand
+func AesGCMEncrypt(file io.Reader, output io.Writer, key []byte) error {
+	return AesGCMEncryptWithRandom(nil, file, output, key)
+}
 
-	encryptedData := aesGCM.Seal(nonce, nonce, fileContents, nil)
-	_, err = output.Write(encryptedData)
-	if err != nil {
-		return IOError{"while writing encrypted data", err}
-	}
-	return nil
+func AesGCMEncryptWithRandom(random RandomSource, file io.Reader, output io.Writer, key []byte) error {
+	return AesGCMEncryptWithAD(random, file, output, key, nil)
+}
+
+// ad is authenticated along with the file but not stored in it, decryption needs the same ad.
+// It can be the file name or path, so encrypted files can't be swapped unnoticed
+func AesGCMEncryptWithAD(random RandomSource, file io.Reader, output io.Writer, key, ad []byte) error {
+	return encryptStream(random, aesGCMCipher{}, key, KDFParams{}, ad, file, output)
+}
+
+// decrypts n bytes starting at off without decrypting the whole file, the cipher is taken from the header.
+// Returns io.EOF along with the decrypted bytes if the range goes past the end of the file
+func DecryptRange(r io.ReaderAt, key []byte, off, n int64) ([]byte, error) {
+	return decryptRange(key, nil, r, off, n)
 }
 
+// decrypts files of the segmented format as well as the nonce || ciphertext files of older versions
 func AesGCMDecrypt(file io.Reader, output io.Writer, key []byte) error {
-    c, err := aes.NewCipher(key)
-    if err != nil {
-    	return IOError{"while creating decryption cipher", err}
+	return AesGCMDecryptWithAD(file, output, key, nil)
+}
+
+func AesGCMDecryptWithAD(file io.Reader, output io.Writer, key, ad []byte) error {
+	in := bufio.NewReader(file)
+	segmented, err := isSegmented(in)
+	if err != nil {
+		return err
+	}
+	if segmented {
+		return decryptStream(aesGCMCipher{}, key, ad, in, output)
 	}
 
-	aesGCM, err := cipher.NewGCM(c)
+	aesGCM, err := newAesGCM(key)
 	if err != nil {
-		return IOError{"while creating decryption GCM", err}
+		return err
 	}
 
 	nonceSize := aesGCM.NonceSize()
 
-	data, err := ioutil.ReadAll(file)
+	data, err := ioutil.ReadAll(in)
 	if err != nil {
 		return IOError{"while reading the file", err}
 	}
 
 	if len(data) < nonceSize {
-		return fmt.Errorf("encrypted file must be bigger than nonce size")
+		return errorf(ErrShortCiphertext, "encrypted file must be bigger than nonce size")
 	}
 
 	nonce, data := data[:nonceSize], data[nonceSize:]
-	decrypted, err := aesGCM.Open(nil, nonce, data, nil)
+	decrypted, err := aesGCM.Open(nil, nonce, data, ad)
 	if err != nil {
-		return IOError{"while decrypting", err}
+		return IOError{"while decrypting", ErrAuthenticationFailed}
 	}
 
 	_, err = output.Write(decrypted)
@@ -0,0 +1,110 @@
+package bitsplit
+
+import (
+	"bytes"
+	"crypto/aes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestSplitJoin(t *testing.T) {
+	for _, n := range []int{2, 3, 5} {
+		for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 2 * chunkSize} {
+			data := randomBytes(size)
+			shares := splitToBuffers(t, n, func(keys []io.Writer) error {
+				return Split(bytes.NewReader(data), keys)
+			})
+			// the keys can be given in any order
+			shares[0], shares[n-1] = shares[n-1], shares[0]
+			var out bytes.Buffer
+			err := Join(&out, shareReaders(shares...))
+			if err != nil {
+				t.Fatalf("n=%d size %d: %v", n, size, err)
+			}
+			if !bytes.Equal(out.Bytes(), data) {
+				t.Fatalf("n=%d size %d: joined data differs", n, size)
+			}
+			err = Join(io.Discard, shareReaders(shares[1:]...))
+			if err == nil {
+				t.Fatalf("n=%d size %d: joined without all keys", n, size)
+			}
+		}
+	}
+}
+
+func TestJoinLegacy(t *testing.T) {
+	// keys without a header are summed up byte by byte
+	var out bytes.Buffer
+	err := Join(&out, shareReaders([]byte{1, 2, 250}, []byte{3, 4, 10}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), []byte{4, 6, 4}) {
+		t.Fatalf("got %v", out.Bytes())
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	data := randomBytes(1000)
+	additive := splitToBuffers(t, 3, func(keys []io.Writer) error {
+		return Split(bytes.NewReader(data), keys)
+	})
+	other := splitToBuffers(t, 3, func(keys []io.Writer) error {
+		return Split(bytes.NewReader(data), keys)
+	})
+	shamir := splitToBuffers(t, 4, func(keys []io.Writer) error {
+		return SplitThreshold(nil, bytes.NewReader(data), keys, 3)
+	})
+	encrypted := encryptToBytes(t, data)
+	wrongKey := bytes.Repeat([]byte{8}, 32)
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"one key", Join(io.Discard, shareReaders(additive[0])), ErrTooFewShares},
+		{"missing key", Join(io.Discard, shareReaders(additive[:2]...)), ErrTooFewShares},
+		{"below threshold", JoinThreshold(io.Discard, shareReaders(shamir[:2]...)), ErrTooFewShares},
+		{"different splits", Join(io.Discard, shareReaders(additive[0], other[1], other[2])), ErrShareMismatch},
+		{"repeated key", Join(io.Discard, shareReaders(additive[0], additive[0], additive[1])), ErrShareMismatch},
+		{"wrong scheme", JoinWith(xorScheme{}, io.Discard, shareReaders(additive...)), ErrShareMismatch},
+		{"altered key", Join(io.Discard, shareReaders(additive[0], alterPayload(additive[1], 3), additive[2])),
+			ErrReconstructionMismatch},
+		{"truncated key", Join(io.Discard, shareReaders(additive[0], additive[1][:len(additive[1])-5], additive[2])),
+			ErrShareDamaged},
+		{"wrong key", AesGCMDecrypt(bytes.NewReader(encrypted), io.Discard, wrongKey), ErrAuthenticationFailed},
+		{"truncated header", AesGCMDecrypt(bytes.NewReader(encrypted[:10]), io.Discard, testKey),
+			ErrShortCiphertext},
+		{"short key", AesGCMEncrypt(bytes.NewReader(data), io.Discard, testKey[:5]), ErrBadKeySize},
+	}
+	for _, test := range tests {
+		if !errors.Is(test.err, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, test.err, test.want)
+		}
+	}
+}
+
+func TestIOErrorUnwrap(t *testing.T) {
+	err := Join(failingWriter{}, shareReaders([]byte{1}, []byte{2}))
+	var ioErr IOError
+	if !errors.As(err, &ioErr) || !errors.Is(err, errFailingWriter) {
+		t.Fatalf("got %v, want IOError wrapping the write error", err)
+	}
+
+	// the cipher's own error stays in the chain under the sentinel
+	err = AesGCMEncrypt(bytes.NewReader(nil), io.Discard, testKey[:5])
+	var sizeErr aes.KeySizeError
+	if !errors.Is(err, ErrBadKeySize) || !errors.As(err, &sizeErr) {
+		t.Fatalf("got %v, want ErrBadKeySize wrapping aes.KeySizeError", err)
+	}
+}
+
+var errFailingWriter = errors.New("write failed")
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errFailingWriter
+}
@@ -0,0 +1,37 @@
+package bitsplit
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// XChaCha20-Poly1305 uses 32 byte keys. Its nonces are 24 bytes long, so random nonce prefixes
+// never collide in practice, and it is fast on machines without AES instructions
+
+func newXChaCha20Poly1305(key []byte) (cipher.AEAD, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, IOError{"while creating xchacha20-poly1305 cipher", withSentinel(ErrBadKeySize, err)}
+	}
+	return aead, nil
+}
+
+func XChaCha20Poly1305Encrypt(random RandomSource, file io.Reader, output io.Writer, key []byte) error {
+	return encryptStream(random, xChaCha20Poly1305Cipher{}, key, KDFParams{}, nil, file, output)
+}
+
+func XChaCha20Poly1305Decrypt(file io.Reader, output io.Writer, key []byte) error {
+	in := bufio.NewReader(file)
+	segmented, err := isSegmented(in)
+	if err != nil {
+		return err
+	}
+	if !segmented {
+		return fmt.Errorf("file is not encrypted by bitsplit")
+	}
+	return decryptStream(xChaCha20Poly1305Cipher{}, key, nil, in, output)
+}
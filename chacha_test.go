@@ -0,0 +1,34 @@
+package bitsplit
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestXChaCha20Poly1305(t *testing.T) {
+	data := randomBytes(segmentSize + 1)
+	var encrypted bytes.Buffer
+	err := XChaCha20Poly1305Encrypt(nil, bytes.NewReader(data), &encrypted, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	err = XChaCha20Poly1305Decrypt(bytes.NewReader(encrypted.Bytes()), &out, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("decrypted data differs")
+	}
+
+	// an AES-GCM key of the same size doesn't open it
+	err = AesGCMDecrypt(bytes.NewReader(encrypted.Bytes()), io.Discard, testKey)
+	if err == nil {
+		t.Fatal("decrypted with AES-GCM")
+	}
+	err = XChaCha20Poly1305Encrypt(nil, bytes.NewReader(data), io.Discard, testKey[:16])
+	if err == nil {
+		t.Fatal("encrypted with a 16 byte key")
+	}
+}
@@ -0,0 +1,180 @@
+package bitsplit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Cipher is an encryption algorithm the command line tools can choose by name
+type Cipher interface {
+	Name() string
+	KeySize() int
+	Encrypt(random RandomSource, file io.Reader, output io.Writer, key []byte) error
+	Decrypt(file io.Reader, output io.Writer, key []byte) error
+}
+
+var ciphers = make(map[string]Cipher)
+
+// makes the cipher available by its name, panics if the name is already taken
+func RegisterCipher(c Cipher) {
+	if _, ok := ciphers[c.Name()]; ok {
+		panic("bitsplit: cipher " + c.Name() + " is registered twice")
+	}
+	ciphers[c.Name()] = c
+}
+
+func GetCipher(name string) (Cipher, error) {
+	c, ok := ciphers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown cipher %s", name)
+	}
+	return c, nil
+}
+
+// sorted names of registered ciphers
+func CipherNames() []string {
+	names := make([]string, 0, len(ciphers))
+	for name := range ciphers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// built in ciphers, the algorithm id is stored in the header of encrypted files
+type aeadCipher interface {
+	Cipher
+	algorithm() uint8
+	newAEAD(key []byte) (cipher.AEAD, error)
+}
+
+const (
+	algorithmAesGCM            = 1
+	algorithmXChaCha20Poly1305 = 2
+)
+
+func cipherByAlgorithm(id uint8) (aeadCipher, error) {
+	for _, c := range ciphers {
+		if ac, ok := c.(aeadCipher); ok && ac.algorithm() == id {
+			return ac, nil
+		}
+	}
+	return nil, fmt.Errorf("file is encrypted with unknown algorithm %d", id)
+}
+
+// decrypts files of any built in cipher, the algorithm is taken from the header.
+// Files without a header are decrypted as AES-GCM files of older versions
+func Decrypt(file io.Reader, output io.Writer, key []byte) error {
+	return DecryptWithAD(file, output, key, nil)
+}
+
+func DecryptWithAD(file io.Reader, output io.Writer, key, ad []byte) error {
+	in := bufio.NewReader(file)
+	segmented, err := isSegmented(in)
+	if err != nil {
+		return err
+	}
+	if !segmented {
+		return AesGCMDecryptWithAD(in, output, key, ad)
+	}
+	return decryptStream(nil, key, ad, in, output)
+}
+
+func EncryptWithPassphrase(c Cipher, random RandomSource, file io.Reader, output io.Writer,
+	passphrase []byte, params KDFParams) error {
+	ac, ok := c.(aeadCipher)
+	if !ok {
+		return fmt.Errorf("cipher %s doesn't support passphrases", c.Name())
+	}
+	if params.KDF == KDFNone {
+		return fmt.Errorf("no key derivation function given")
+	}
+
+	err := readRandom(random, params.Salt[:])
+	if err != nil {
+		return err
+	}
+	key, err := params.DeriveKey(passphrase, c.KeySize())
+	if err != nil {
+		return err
+	}
+	return encryptStream(random, ac, key, params, nil, file, output)
+}
+
+// the key derivation parameters are taken from the header of the file.
+// If c is nil, the cipher is taken from the header too
+func DecryptWithPassphrase(c Cipher, file io.Reader, output io.Writer, passphrase []byte) error {
+	var ac aeadCipher
+	if c != nil {
+		var ok bool
+		ac, ok = c.(aeadCipher)
+		if !ok {
+			return fmt.Errorf("cipher %s doesn't support passphrases", c.Name())
+		}
+	}
+
+	in := bufio.NewReader(file)
+	h, err := readEncryptedHeader(in)
+	if err != nil {
+		return err
+	}
+	err = h.checkCipher(ac)
+	if err != nil {
+		return err
+	}
+	if h.kdf.KDF == KDFNone {
+		return fmt.Errorf("file is encrypted with a key, not a passphrase")
+	}
+
+	key, err := h.kdf.DeriveKey(passphrase, h.cipher.KeySize())
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(keyFingerprint(key), h.fingerprint) {
+		return errorf(ErrAuthenticationFailed, "wrong passphrase")
+	}
+	aead, err := h.openAEAD(key)
+	if err != nil {
+		return err
+	}
+	return decryptSegments(aead, h, nil, in, output)
+}
+
+type aesGCMCipher struct{}
+
+func (aesGCMCipher) Name() string { return "aes" }
+func (aesGCMCipher) KeySize() int { return 32 }
+func (aesGCMCipher) Encrypt(random RandomSource, file io.Reader, output io.Writer, key []byte) error {
+	return AesGCMEncryptWithRandom(random, file, output, key)
+}
+func (aesGCMCipher) Decrypt(file io.Reader, output io.Writer, key []byte) error {
+	return AesGCMDecrypt(file, output, key)
+}
+func (aesGCMCipher) algorithm() uint8 { return algorithmAesGCM }
+func (aesGCMCipher) newAEAD(key []byte) (cipher.AEAD, error) {
+	return newAesGCM(key)
+}
+
+type xChaCha20Poly1305Cipher struct{}
+
+func (xChaCha20Poly1305Cipher) Name() string { return "chacha" }
+func (xChaCha20Poly1305Cipher) KeySize() int { return 32 }
+func (xChaCha20Poly1305Cipher) Encrypt(random RandomSource, file io.Reader, output io.Writer, key []byte) error {
+	return XChaCha20Poly1305Encrypt(random, file, output, key)
+}
+func (xChaCha20Poly1305Cipher) Decrypt(file io.Reader, output io.Writer, key []byte) error {
+	return XChaCha20Poly1305Decrypt(file, output, key)
+}
+func (xChaCha20Poly1305Cipher) algorithm() uint8 { return algorithmXChaCha20Poly1305 }
+func (xChaCha20Poly1305Cipher) newAEAD(key []byte) (cipher.AEAD, error) {
+	return newXChaCha20Poly1305(key)
+}
+
+func init() {
+	RegisterCipher(aesGCMCipher{})
+	RegisterCipher(xChaCha20Poly1305Cipher{})
+}
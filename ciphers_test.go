@@ -0,0 +1,94 @@
+package bitsplit
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCiphers(t *testing.T) {
+	names := CipherNames()
+	if len(names) < 2 || names[0] != "aes" || names[1] != "chacha" {
+		t.Fatalf("registered ciphers %v", names)
+	}
+	data := randomBytes(1000)
+	for _, name := range names {
+		c, err := GetCipher(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := randomBytes(c.KeySize())
+		var encrypted, out bytes.Buffer
+		err = c.Encrypt(nil, bytes.NewReader(data), &encrypted, key)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		err = c.Decrypt(&encrypted, &out, key)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(out.Bytes(), data) {
+			t.Fatalf("%s: decrypted data differs", name)
+		}
+	}
+	if _, err := GetCipher("rot13"); err == nil {
+		t.Fatal("got an unknown cipher")
+	}
+}
+
+func TestRegisterCipherTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("registered aes twice")
+		}
+	}()
+	RegisterCipher(aesGCMCipher{})
+}
+
+func TestDecryptAnyCipher(t *testing.T) {
+	data := randomBytes(segmentSize + 1)
+	for _, name := range CipherNames() {
+		c, _ := GetCipher(name)
+		var encrypted bytes.Buffer
+		err := c.Encrypt(nil, bytes.NewReader(data), &encrypted, testKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		err = Decrypt(bytes.NewReader(encrypted.Bytes()), &out, testKey)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(out.Bytes(), data) {
+			t.Fatalf("%s: decrypted data differs", name)
+		}
+		got, err := DecryptRange(bytes.NewReader(encrypted.Bytes()), testKey, segmentSize-1, 2)
+		if err != nil || !bytes.Equal(got, data[segmentSize-1:]) {
+			t.Fatalf("%s: range decrypted to %v: %v", name, got, err)
+		}
+
+		// the fingerprint tells a wrong key before anything is decrypted
+		err = Decrypt(bytes.NewReader(encrypted.Bytes()), &out, bytes.Repeat([]byte{8}, 32))
+		if err == nil || !strings.Contains(err.Error(), KeyFingerprint(testKey)) {
+			t.Fatalf("%s: wrong key gives %v", name, err)
+		}
+	}
+
+	var encrypted bytes.Buffer
+	err := XChaCha20Poly1305Encrypt(nil, bytes.NewReader(data), &encrypted, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AesGCMDecrypt(&encrypted, io.Discard, testKey)
+	if err == nil || !strings.Contains(err.Error(), "chacha") {
+		t.Fatalf("chacha file decrypted as aes gives %v", err)
+	}
+}
+
+func TestKeyFingerprint(t *testing.T) {
+	a, b := KeyFingerprint(testKey), KeyFingerprint(testKey[:16])
+	if len(a) != 2*keyFingerprintSize || a == b || a != KeyFingerprint(testKey) {
+		t.Fatalf("fingerprints %s %s", a, b)
+	}
+}
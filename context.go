@@ -0,0 +1,133 @@
+package bitsplit
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+// Cancellable variants of the main operations. The input is read through a reader that checks
+// the context before every read, so a cancelled operation stops at the next chunk and returns
+// the error of the context. Whatever was written to the output by then is incomplete
+
+// called with the number of input bytes processed so far and their total, total is -1 if unknown
+type Progress func(done, total int64)
+
+// counts the bytes read through all the readers made by it
+type progressCounter struct {
+	ctx      context.Context
+	progress Progress
+	done     int64
+	total    int64
+}
+
+func newProgressCounter(ctx context.Context, total int64, progress Progress) *progressCounter {
+	return &progressCounter{ctx: ctx, progress: progress, total: total}
+}
+
+func (c *progressCounter) reader(r io.Reader) io.Reader {
+	return &progressReader{r, c}
+}
+
+type progressReader struct {
+	r       io.Reader
+	counter *progressCounter
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	c := r.counter
+	err := c.ctx.Err()
+	if err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		c.done += int64(n)
+		if c.progress != nil {
+			c.progress(c.done, c.total)
+		}
+	}
+	return n, err
+}
+
+// the number of bytes left in r, -1 if it can't be told without reading
+func readerSize(r io.Reader) int64 {
+	switch r := r.(type) {
+	case interface{ Len() int }:
+		return int64(r.Len())
+	case *os.File:
+		info, err := r.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return -1
+		}
+		off, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		return info.Size() - off
+	}
+	return -1
+}
+
+func readersSize(readers []io.Reader) int64 {
+	total := int64(0)
+	for _, r := range readers {
+		size := readerSize(r)
+		if size < 0 {
+			return -1
+		}
+		total += size
+	}
+	return total
+}
+
+// the error of the context is returned instead of the one it caused somewhere down the stack
+func contextError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// reader of r that fails with the error of the context once it is cancelled and reports the bytes read,
+// the total is the size of r if it can be told. It makes any function taking a reader cancellable,
+// like Encrypt of any Cipher
+func NewProgressReader(ctx context.Context, r io.Reader, progress Progress) io.Reader {
+	return newProgressCounter(ctx, readerSize(r), progress).reader(r)
+}
+
+// Split that can be cancelled, progress may be nil
+func SplitContext(ctx context.Context, random RandomSource, file io.Reader, keys []io.Writer, progress Progress) error {
+	err := SplitWithRandom(random, NewProgressReader(ctx, file, progress), keys)
+	return contextError(ctx, err)
+}
+
+// Join that can be cancelled, the progress is of the bytes read from all the keys
+func JoinContext(ctx context.Context, file io.Writer, keys []io.Reader, progress Progress) error {
+	counter := newProgressCounter(ctx, readersSize(keys), progress)
+	keyReaders := make([]io.Reader, len(keys))
+	for i, key := range keys {
+		keyReaders[i] = counter.reader(key)
+	}
+	err := Join(file, keyReaders)
+	return contextError(ctx, err)
+}
+
+func AesGCMEncryptContext(ctx context.Context, random RandomSource, file io.Reader, output io.Writer, key []byte, progress Progress) error {
+	return AesGCMEncryptWithADContext(ctx, random, file, output, key, nil, progress)
+}
+
+func AesGCMEncryptWithADContext(ctx context.Context, random RandomSource, file io.Reader, output io.Writer, key, ad []byte, progress Progress) error {
+	err := AesGCMEncryptWithAD(random, NewProgressReader(ctx, file, progress), output, key, ad)
+	return contextError(ctx, err)
+}
+
+// the progress is of the encrypted file read
+func AesGCMDecryptContext(ctx context.Context, file io.Reader, output io.Writer, key []byte, progress Progress) error {
+	return AesGCMDecryptWithADContext(ctx, file, output, key, nil, progress)
+}
+
+func AesGCMDecryptWithADContext(ctx context.Context, file io.Reader, output io.Writer, key, ad []byte, progress Progress) error {
+	err := AesGCMDecryptWithAD(NewProgressReader(ctx, file, progress), output, key, ad)
+	return contextError(ctx, err)
+}
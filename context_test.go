@@ -0,0 +1,123 @@
+package bitsplit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+// records the progress calls
+type progressLog struct {
+	done, total []int64
+}
+
+func (l *progressLog) progress(done, total int64) {
+	l.done = append(l.done, done)
+	l.total = append(l.total, total)
+}
+
+func (l *progressLog) check(t *testing.T, name string, total int64) {
+	t.Helper()
+	if len(l.done) == 0 {
+		t.Fatalf("%s: no progress reported", name)
+	}
+	for i, done := range l.done {
+		if l.total[i] != total || i > 0 && done <= l.done[i-1] {
+			t.Fatalf("%s: progress %d of %d after %d", name, done, l.total[i], l.done[:i])
+		}
+	}
+	if last := l.done[len(l.done)-1]; total >= 0 && last != total {
+		t.Fatalf("%s: progress ended at %d of %d", name, last, total)
+	}
+}
+
+func TestContextProgress(t *testing.T) {
+	data := randomBytes(2*chunkSize + 3)
+	var split progressLog
+	keys := splitToBuffers(t, 3, func(keys []io.Writer) error {
+		return SplitContext(context.Background(), nil, bytes.NewReader(data), keys, split.progress)
+	})
+	split.check(t, "split", int64(len(data)))
+
+	var join progressLog
+	var out bytes.Buffer
+	err := JoinContext(context.Background(), &out, shareReaders(keys...), join.progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("joined data differs")
+	}
+	join.check(t, "join", int64(len(keys[0])+len(keys[1])+len(keys[2])))
+
+	var encrypt, decrypt progressLog
+	var encrypted bytes.Buffer
+	err = AesGCMEncryptContext(context.Background(), nil, bytes.NewReader(data), &encrypted, testKey, encrypt.progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypt.check(t, "encrypt", int64(len(data)))
+	size := int64(encrypted.Len())
+	out.Reset()
+	err = AesGCMDecryptContext(context.Background(), &encrypted, &out, testKey, decrypt.progress)
+	if err != nil || !bytes.Equal(out.Bytes(), data) {
+		t.Fatalf("decrypt: %v", err)
+	}
+	decrypt.check(t, "decrypt", size)
+}
+
+func TestContextUnknownSize(t *testing.T) {
+	// a reader without Len, like a pipe
+	data := randomBytes(1000)
+	var log progressLog
+	splitToBuffers(t, 2, func(keys []io.Writer) error {
+		return SplitContext(context.Background(), nil, io.MultiReader(bytes.NewReader(data)), keys, log.progress)
+	})
+	log.check(t, "split", -1)
+}
+
+func TestContextCancel(t *testing.T) {
+	data := randomBytes(3 * chunkSize)
+	ctx, cancel := context.WithCancel(context.Background())
+	// cancelled once the first chunk is read
+	progress := func(done, total int64) { cancel() }
+	keys := []io.Writer{io.Discard, io.Discard}
+	err := SplitContext(ctx, nil, bytes.NewReader(data), keys, progress)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("split: got %v, want context.Canceled", err)
+	}
+
+	err = AesGCMEncryptContext(ctx, nil, bytes.NewReader(data), io.Discard, testKey, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("encrypt: got %v, want context.Canceled", err)
+	}
+	err = JoinContext(ctx, io.Discard, shareReaders([]byte{1}, []byte{2}), nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("join: got %v, want context.Canceled", err)
+	}
+}
+
+func TestProgressReader(t *testing.T) {
+	data := randomBytes(segmentSize + 10)
+	for _, name := range CipherNames() {
+		c, _ := GetCipher(name)
+		var log progressLog
+		var encrypted bytes.Buffer
+		r := NewProgressReader(context.Background(), bytes.NewReader(data), log.progress)
+		err := c.Encrypt(nil, r, &encrypted, testKey)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		log.check(t, name, int64(len(data)))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		r = NewProgressReader(ctx, bytes.NewReader(data), nil)
+		err = EncryptWithPassphrase(c, nil, r, io.Discard, []byte("pass"), testKDFParams[1])
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("%s: got %v, want context.Canceled", name, err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package bitsplit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// rewrites a share in the first format version, with a crc32 instead of the digests
+func toVersion1(share []byte) []byte {
+	n := len(share) - shareTrailerSize
+	v1 := make([]byte, n+shareTrailerSizeV1)
+	copy(v1, share[:n])
+	v1[4] = 1
+	binary.BigEndian.PutUint64(v1[n:], uint64(n-shareHeaderSize))
+	binary.BigEndian.PutUint32(v1[n+8:], crc32.ChecksumIEEE(v1[:n+8]))
+	return v1
+}
+
+// alters a byte of the data after the salt and fixes the tag, so only the digest finds it
+func alterPayload(share []byte, i int) []byte {
+	share = append([]byte(nil), share...)
+	share[shareHeaderSize+saltSize+i] ^= 0x20
+	return retag(share)
+}
+
+func TestJoinDigestMismatch(t *testing.T) {
+	data := randomBytes(chunkSize + 10)
+	keys := splitToBuffers(t, 3, func(keys []io.Writer) error {
+		return Split(bytes.NewReader(data), keys)
+	})
+	keys[1] = alterPayload(keys[1], chunkSize+5)
+	err := Join(io.Discard, shareReaders(keys...))
+	if !errors.Is(err, ErrReconstructionMismatch) {
+		t.Fatalf("got %v, want ErrReconstructionMismatch", err)
+	}
+}
+
+func TestJoinDigestDisagree(t *testing.T) {
+	data := randomBytes(100)
+	keys := splitToBuffers(t, 2, func(keys []io.Writer) error {
+		return Split(bytes.NewReader(data), keys)
+	})
+	// the digest is right after the payload length
+	i := len(keys[0]) - shareTrailerSize + 8
+	keys[0] = append([]byte(nil), keys[0]...)
+	keys[0][i] ^= 1
+	keys[0] = retag(keys[0])
+	if Join(io.Discard, shareReaders(keys...)) == nil {
+		t.Fatal("joined keys with different digests")
+	}
+}
+
+func TestJoinVersion1(t *testing.T) {
+	data := randomBytes(chunkSize + 10)
+	keys := splitToBuffers(t, 4, func(keys []io.Writer) error {
+		return SplitThreshold(nil, bytes.NewReader(data), keys, 3)
+	})
+	for i := range keys {
+		keys[i] = toVersion1(keys[i])
+	}
+	var out bytes.Buffer
+	err := Join(&out, shareReaders(keys...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("joined data differs")
+	}
+
+	keys[2] = append([]byte(nil), keys[2]...)
+	keys[2][shareHeaderSize] ^= 1
+	if Join(io.Discard, shareReaders(keys[:3]...)) == nil {
+		t.Fatal("joined a damaged version 1 key")
+	}
+}
+
+func TestJoinFromFilesChecksFirst(t *testing.T) {
+	data := randomBytes(chunkSize + 10)
+	keys := splitToBuffers(t, 3, func(keys []io.Writer) error {
+		return Split(bytes.NewReader(data), keys)
+	})
+	keys[2] = alterPayload(keys[2], 0)
+
+	dir := t.TempDir()
+	files := make([]*os.File, len(keys))
+	for i, key := range keys {
+		name := filepath.Join(dir, "key"+string(rune('0'+i)))
+		err := ioutil.WriteFile(name, key, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[i], err = os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer files[i].Close()
+	}
+
+	var out bytes.Buffer
+	err := JoinFromFiles(&out, files)
+	if !errors.Is(err, ErrReconstructionMismatch) {
+		t.Fatalf("got %v, want ErrReconstructionMismatch", err)
+	}
+	if out.Len() != 0 {
+		t.Fatalf("%d bytes written before the digest was checked", out.Len())
+	}
+}
+
+func TestSaltedDigest(t *testing.T) {
+	data := randomBytes(100)
+	var digests [][]byte
+	for _, s := range []Scheme{additiveScheme{}, krawczykScheme{}} {
+		for range [2]struct{}{} {
+			keys := splitToBuffers(t, 3, func(keys []io.Writer) error {
+				return SplitWith(s, nil, bytes.NewReader(data), keys, 3)
+			})
+			if keys[0][6]&shareFlagSalted == 0 {
+				t.Fatalf("%s: share isn't salted", s.Name())
+			}
+			n := len(keys[0]) - sha256.Size
+			digests = append(digests, keys[0][n-sha256.Size:n])
+			var out bytes.Buffer
+			err := Join(&out, shareReaders(keys...))
+			if err != nil || !bytes.Equal(out.Bytes(), data) {
+				t.Fatalf("%s: joined %d bytes: %v", s.Name(), out.Len(), err)
+			}
+		}
+	}
+	// the same data never gets the same digest
+	plain := sha256.Sum256(data)
+	for i, d := range digests {
+		if bytes.Equal(d, plain[:]) || i > 0 && bytes.Equal(d, digests[i-1]) {
+			t.Fatalf("digest %d repeats: %x", i, d)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package bitsplit
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Enrolling a new share holder: the share with a new index is interpolated from threshold many
+// shares of a shamir split, the file is never restored. The new share has the same split set id
+// and parameters as the others, so it can be joined with them
+
+// writes to output the share with the given index of the split the keys belong to.
+// The index must not be given to any other holder, the keys only tell which ones the given holders have.
+// More keys than the threshold are used to find altered ones like in JoinThresholdDetect
+func Enroll(keys []io.Reader, output io.Writer, index int) error {
+	if len(keys) < 2 {
+		return errLessThanTwoKeys
+	}
+	readers := make([]*shareReader, len(keys))
+	headers := make([]shareHeader, len(keys))
+	for i, key := range keys {
+		var err error
+		readers[i], err = newShareReader(key)
+		if err != nil {
+			return fmt.Errorf("key %d: %w", i, err)
+		}
+		if readers[i].legacy {
+			return errorf(ErrShareMismatch, "key %d: not a share file", i)
+		}
+		headers[i] = readers[i].shareHeader
+	}
+	err := checkShareSet(headers)
+	if err != nil {
+		return err
+	}
+	header := headers[0]
+	if header.Scheme != SchemeShamir {
+		return fmt.Errorf("keys are split with %s, only shamir keys can be enrolled", header.Scheme)
+	}
+
+	if index < 1 || index > 255 {
+		return fmt.Errorf("share index must be between 1 and 255, got %d", index)
+	}
+	for i, h := range headers {
+		if int(h.Index) == index {
+			return fmt.Errorf("key %d is already the share %d", i, index)
+		}
+	}
+
+	header.Index = uint16(index)
+	w, err := newShareWriter(output, header)
+	if err != nil {
+		return err
+	}
+	bad, err := joinRobust(w, readers, headers, byte(index))
+	newJoinReport(readers, bad).warn()
+	if err != nil {
+		return err
+	}
+	w.digest, err = sharesDigest(readers)
+	if err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+func EnrollFromFiles(keys []*os.File, output *os.File, index int) error {
+	keyReaders := make([]io.Reader, len(keys))
+	for i, key := range keys {
+		keyReaders[i] = key
+	}
+	return Enroll(keyReaders, output, index)
+}
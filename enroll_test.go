@@ -0,0 +1,68 @@
+package bitsplit
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func enrollToBuffer(t *testing.T, keys [][]byte, index int) []byte {
+	var out bytes.Buffer
+	err := Enroll(shareReaders(keys...), &out, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestEnroll(t *testing.T) {
+	data := randomBytes(2*chunkSize + 9)
+	keys := splitToBuffers(t, 4, func(keys []io.Writer) error {
+		return SplitThreshold(nil, bytes.NewReader(data), keys, 3)
+	})
+
+	// an index past the total and one of a lost key
+	for _, index := range []int{7, 2} {
+		enrolled := enrollToBuffer(t, [][]byte{keys[0], keys[2], keys[3]}, index)
+		if index == 2 && !bytes.Equal(enrolled, keys[1]) {
+			t.Fatal("enrolled share 2 differs from the lost one")
+		}
+
+		var out bytes.Buffer
+		err := Join(&out, shareReaders(keys[2], enrolled, keys[0]))
+		if err != nil {
+			t.Fatalf("index %d: %v", index, err)
+		}
+		if !bytes.Equal(out.Bytes(), data) {
+			t.Fatalf("index %d: joined data differs", index)
+		}
+	}
+}
+
+func TestEnrollRefused(t *testing.T) {
+	keys := splitToBuffers(t, 4, func(keys []io.Writer) error {
+		return SplitThreshold(nil, bytes.NewReader(randomBytes(100)), keys, 3)
+	})
+	tests := []struct {
+		name  string
+		keys  [][]byte
+		index int
+	}{
+		{"too few keys", keys[:2], 6},
+		{"taken index", keys[:3], 2},
+		{"index too large", keys[:3], 256},
+		{"no index", keys[:3], 0},
+	}
+	for _, test := range tests {
+		if Enroll(shareReaders(test.keys...), io.Discard, test.index) == nil {
+			t.Errorf("%s: enrolled", test.name)
+		}
+	}
+
+	additive := splitToBuffers(t, 3, func(keys []io.Writer) error {
+		return Split(bytes.NewReader(randomBytes(100)), keys)
+	})
+	if Enroll(shareReaders(additive...), io.Discard, 4) == nil {
+		t.Error("enrolled into an additive split")
+	}
+}
@@ -1,16 +1,19 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/hex"
 	"flag"
 	"fmt"
 	"github.com/imobulus/bitsplit"
 	"github.com/imobulus/bitsplit/osutil"
+	"io"
 	"io/ioutil"
 	"log"
-	"math/rand"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -34,6 +37,65 @@ func askForRewrite(fileName string) {
 	}
 }
 
+// removes the incomplete outputs and exits if the operation failed because it was interrupted
+func exitIfInterrupted(ctx context.Context, err error, outputs ...*os.File) {
+	if err == nil || ctx.Err() == nil {
+		return
+	}
+	for _, output := range outputs {
+		output.Close()
+		os.Remove(output.Name())
+	}
+	errLog.Fatal("interrupted")
+}
+
+// creates a temporary file next to name to write an output to, so a failed operation never leaves
+// a partial or unchecked file under name. finishOutput moves it there
+func createOutput(name string) (*os.File, error) {
+	return ioutil.TempFile(filepath.Dir(name), filepath.Base(name)+".*.part")
+}
+
+// renames the output to name if err is nil, otherwise removes it and returns err
+func finishOutput(output *os.File, name string, err error) error {
+	closeErr := output.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(output.Name())
+		return err
+	}
+	return os.Rename(output.Name(), name)
+}
+
+// reads the passphrase from the file descriptor fd, or asks for it without echo if fd is negative
+func readPassphrase(fd int, confirm bool) []byte {
+	var passphrase []byte
+	if fd >= 0 {
+		line, err := bufio.NewReader(os.NewFile(uintptr(fd), "passphrase")).ReadString('\n')
+		if err != nil && err != io.EOF {
+			errorFatal("while reading passphrase", err)
+		}
+		passphrase = []byte(strings.TrimRight(line, "\r\n"))
+	} else {
+		var err error
+		passphrase, err = osutil.ReadPassword("passphrase: ")
+		errorFatal("while reading passphrase", err)
+		if confirm {
+			again, err := osutil.ReadPassword("repeat passphrase: ")
+			errorFatal("while reading passphrase", err)
+			if !bytes.Equal(passphrase, again) {
+				errLog.Fatal("passphrases don't match")
+			}
+		}
+	}
+
+	if len(passphrase) == 0 {
+		errLog.Fatal("empty passphrase")
+	}
+	return passphrase
+}
+
 //---- command line executives ----
 func OpenViaInfo(infFileName string) (*os.File, []*os.File, error) {
 	infoBytes, err := ioutil.ReadFile(infFileName)
@@ -72,10 +134,30 @@ func DoSplit(args []string) {
 	splitMode := flag.NewFlagSet("split", flag.ExitOnError)
 	splitKeyCount := splitMode.Int("k", 2, "the number of summons file will be split to")
 	splitForceRewrite := splitMode.Bool("f", false, "force rewriting key files")
+	splitThreshold := splitMode.Int("t", 0,
+		"the number of summons enough to restore the file, by default all of them are required")
+	splitScheme := splitMode.String("scheme", "",
+		"splitting scheme: "+strings.Join(bitsplit.SchemeNames(), ", ")+
+			". By default shamir with -t and additive without")
+	splitHybrid := splitMode.Bool("hybrid", false,
+		"encrypt the file once and split only the key, the summons are small")
+	splitEncrypted := splitMode.String("enc", "",
+		"encrypted file for -hybrid and -verifiable, by default (input file).enc")
+	splitVerifiable := splitMode.Bool("verifiable", false,
+		"like -hybrid, but the summons can be checked with verify-share against published commitments")
+	splitCommitments := splitMode.String("commitments", "",
+		"commitments file for -verifiable, by default (input file).commitments")
+	splitParity := splitMode.Bool("parity", false,
+		"add Reed-Solomon parity to the summons, so damaged bytes can be corrected")
+	splitPolicy := splitMode.String("policy", "",
+		"split by a policy like and(2of(a,b,c),1of(x,y)), every holder gets a summon (input file).(holder).key")
 
 	splitMode.Parse(args)
 	splitTail := splitMode.Args()
 	splitCountProvided := osutil.IsFlagPassed("k")
+	if *splitParity && (*splitHybrid || *splitVerifiable || *splitPolicy != "") {
+		errLog.Fatal("-parity doesn't work with -hybrid, -verifiable and -policy")
+	}
 
 	if len(splitTail) == 0 {
 		errLog.Fatal("no specification given")
@@ -89,6 +171,12 @@ func DoSplit(args []string) {
 	file, err := os.Open(splitFileName)
 	errorFatal("while opening input file", err)
 
+	if *splitPolicy != "" {
+		defer file.Close()
+		splitByPolicy(file, splitFileName, splitTail, *splitPolicy, *splitEncrypted, *splitForceRewrite)
+		return
+	}
+
 	var keyFiles []*os.File
 
 	if len(splitTail) > 0 {
@@ -136,29 +224,175 @@ func DoSplit(args []string) {
 		}
 	}()
 
-	rand.Seed(bitsplit.GetSeed())
-	err = bitsplit.SplitIntoFiles(file, keyFiles)
+	threshold := *splitThreshold
+	if threshold == 0 {
+		threshold = len(keyFiles)
+	}
+
+	if *splitHybrid || *splitVerifiable {
+		encFileName := *splitEncrypted
+		if encFileName == "" {
+			encFileName = splitFileName + ".enc"
+		}
+		if !*splitForceRewrite && osutil.FileExists(encFileName) {
+			askForRewrite(encFileName)
+		}
+		encFile, err := os.Create(encFileName)
+		errorFatal("while creating encrypted file", err)
+		defer encFile.Close()
+
+		if *splitVerifiable {
+			commitmentsFileName := *splitCommitments
+			if commitmentsFileName == "" {
+				commitmentsFileName = splitFileName + ".commitments"
+			}
+			if !*splitForceRewrite && osutil.FileExists(commitmentsFileName) {
+				askForRewrite(commitmentsFileName)
+			}
+			commitmentsFile, err := os.Create(commitmentsFileName)
+			errorFatal("while creating commitments file", err)
+			defer commitmentsFile.Close()
+
+			err = bitsplit.SplitVerifiableIntoFiles(nil, file, encFile, commitmentsFile, keyFiles, threshold)
+			errorFatal("while splitting", err)
+			return
+		}
+		err = bitsplit.SplitHybridIntoFiles(nil, file, encFile, keyFiles, threshold)
+		errorFatal("while splitting", err)
+		return
+	}
+
+	switch {
+	case *splitParity:
+		schemeName := *splitScheme
+		if schemeName == "" {
+			schemeName = "additive"
+			if *splitThreshold > 0 {
+				schemeName = "shamir"
+			}
+		}
+		var scheme bitsplit.Scheme
+		scheme, err = bitsplit.GetScheme(schemeName)
+		errorFatal("", err)
+		err = bitsplit.SplitWithParityIntoFiles(scheme, nil, file, keyFiles, threshold)
+	case *splitScheme != "":
+		var scheme bitsplit.Scheme
+		scheme, err = bitsplit.GetScheme(*splitScheme)
+		errorFatal("", err)
+		err = bitsplit.SplitWithIntoFiles(scheme, nil, file, keyFiles, threshold)
+	case *splitThreshold > 0:
+		err = bitsplit.SplitThresholdIntoFiles(nil, file, keyFiles, *splitThreshold)
+	default:
+		keyWriters := make([]io.Writer, len(keyFiles))
+		for i, key := range keyFiles {
+			keyWriters[i] = key
+		}
+		ctx, stop := osutil.InterruptContext()
+		defer stop()
+		err = bitsplit.SplitContext(ctx, nil, file, keyWriters, osutil.ProgressBar("splitting"))
+		exitIfInterrupted(ctx, err, keyFiles...)
+	}
 	errorFatal("while splitting", err)
 }
 
+func splitByPolicy(file *os.File, fileName string, keyFileNames []string, policyText, encFileName string, force bool) {
+	policy, err := bitsplit.ParsePolicy(policyText)
+	errorFatal("", err)
+	holders := policy.Holders()
+	if len(keyFileNames) == 0 {
+		for _, holder := range holders {
+			keyFileNames = append(keyFileNames, fmt.Sprintf("%s.%s.key", fileName, holder))
+		}
+	} else if len(keyFileNames) != len(holders) {
+		errLog.Fatalf("the policy has %d holders, but %d key files are given", len(holders), len(keyFileNames))
+	}
+	if encFileName == "" {
+		encFileName = fileName + ".enc"
+	}
+
+	for _, name := range append([]string{encFileName}, keyFileNames...) {
+		if !force && osutil.FileExists(name) {
+			askForRewrite(name)
+		}
+	}
+	encFile, err := os.Create(encFileName)
+	errorFatal("while creating encrypted file", err)
+	defer encFile.Close()
+	keyFiles := make([]*os.File, len(keyFileNames))
+	for i, name := range keyFileNames {
+		keyFiles[i], err = os.Create(name)
+		errorFatal("while creating output file", err)
+		defer keyFiles[i].Close()
+	}
+
+	err = bitsplit.SplitPolicyIntoFiles(nil, file, encFile, policy, keyFiles)
+	errorFatal("while splitting", err)
+	for i, holder := range holders {
+		stdLog.Printf("%s: %s\n", holder, keyFileNames[i])
+	}
+}
+
 func DoJoin(args []string) {
 	joinMode := flag.NewFlagSet("join", flag.ExitOnError)
 	joinConfig := joinMode.String("config", "",
 		"configuration file with the output file and list of keys, optional")
+	joinHybrid := joinMode.Bool("hybrid", false,
+		"join keys made by split -hybrid, the encrypted file goes before the keys")
+	joinVerifiable := joinMode.Bool("verifiable", false,
+		"join keys made by split -verifiable, the encrypted file and the commitments go before the keys")
+	joinPolicy := joinMode.Bool("policy", false,
+		"join keys made by split -policy, the encrypted file goes before the keys")
+	joinScheme := joinMode.String("scheme", "",
+		"refuse keys split with another scheme, by default the scheme is taken from the keys")
 
 	joinMode.Parse(args)
 	joinTail := joinMode.Args()
+
+	join := func(file *os.File, keyFiles []*os.File) error {
+		switch {
+		case *joinVerifiable:
+			if len(keyFiles) < 2 {
+				errLog.Fatal("no encrypted file or commitments given")
+			}
+			return bitsplit.JoinVerifiableFromFiles(file, keyFiles[0], keyFiles[1], keyFiles[2:])
+		case *joinPolicy:
+			if len(keyFiles) == 0 {
+				errLog.Fatal("no encrypted file given")
+			}
+			return bitsplit.JoinPolicyFromFiles(file, keyFiles[0], keyFiles[1:])
+		case *joinHybrid:
+			if len(keyFiles) == 0 {
+				errLog.Fatal("no encrypted file given")
+			}
+			return bitsplit.JoinHybridFromFiles(file, keyFiles[0], keyFiles[1:])
+		case *joinScheme != "":
+			scheme, err := bitsplit.GetScheme(*joinScheme)
+			if err != nil {
+				return err
+			}
+			return bitsplit.JoinWithFromFiles(scheme, file, keyFiles)
+		}
+		ctx, stop := osutil.InterruptContext()
+		defer stop()
+		err := bitsplit.JoinFromFilesContext(ctx, file, keyFiles, osutil.ProgressBar("joining"))
+		exitIfInterrupted(ctx, err, file)
+		return err
+	}
 	if osutil.IsFlagPassed("config") {
 		file, keyFiles, err := OpenViaInfo(*joinConfig)
 		errorFatal("while opening via config", err)
 		defer func() {
-			file.Close()
 			for _, key := range keyFiles {
 				key.Close()
 			}
 		}()
 
-		err = bitsplit.JoinFromFiles(file, keyFiles)
+		// the joined data is checked only at the end, don't leave it if that fails
+		err = join(file, keyFiles)
+		file.Close()
+		if err != nil {
+			os.Remove(file.Name())
+		}
 		errorFatal("while joining", err)
 	} else {
 		if len(joinTail) == 0 {
@@ -168,172 +402,395 @@ func DoJoin(args []string) {
 		if joinOutput == "" {
 			errLog.Fatal("no output file given")
 		}
-		file, err := os.Create(joinOutput)
-		if err != nil {
-			errLog.Fatal("while opening output: " + err.Error())
-		}
 		keyFiles := make([]*os.File, len(joinTail))
 		for i, keyName := range joinTail {
+			var err error
 			keyFiles[i], err = os.Open(keyName)
 			errorFatal("while opening key", err)
 		}
-
 		defer func() {
-			file.Close()
 			for _, key := range keyFiles {
 				key.Close()
 			}
 		}()
+		file, err := createOutput(joinOutput)
+		if err != nil {
+			errLog.Fatal("while opening output: " + err.Error())
+		}
 
-		err = bitsplit.JoinFromFiles(file, keyFiles)
+		// the joined data is checked only at the end, it replaces the output only then
+		err = finishOutput(file, joinOutput, join(file, keyFiles))
 		errorFatal("while joining", err)
 	}
 
 }
 
-func DoEncryptAES(args []string) {
-	aesEncMode := flag.NewFlagSet("encrypt-aes", flag.ExitOnError)
-	aesEncKey := aesEncMode.String("key", "", "AES key in hex format")
-	aesEncRewrite := aesEncMode.Bool("r", false, "use this flag to rewrite input file with encrypted data")
-	aesEncForce := aesEncMode.Bool("f", false, "use this flag to force rewriting")
-	aesEncHex := aesEncMode.Bool("hex", false, "use this flag to save key in hex representation")
-	aesEncReuse := aesEncMode.Bool("reuse-key", false,
-		"this flag uses key saved in <key file> if it exists. It does nothing when -key is specified")
+func DoRefresh(args []string) {
+	refreshMode := flag.NewFlagSet("refresh", flag.ExitOnError)
+	refreshMode.Parse(args)
+	keyFileNames := refreshMode.Args()
+	if len(keyFileNames) < 2 {
+		errLog.Fatal("usage: refresh (key files)")
+	}
+
+	// the refreshed keys are written next to the old ones and replace them once all of them are written
+	keyFiles := make([]*os.File, len(keyFileNames))
+	newFiles := make([]*os.File, len(keyFileNames))
+	removeNew := func() {
+		for _, f := range newFiles {
+			if f != nil {
+				f.Close()
+				os.Remove(f.Name())
+			}
+		}
+	}
+	for i, name := range keyFileNames {
+		var err error
+		keyFiles[i], err = os.Open(name)
+		if err != nil {
+			removeNew()
+			errorFatal("while opening key", err)
+		}
+		defer keyFiles[i].Close()
+		newFiles[i], err = os.Create(name + ".refresh")
+		if err != nil {
+			removeNew()
+			errorFatal("while creating refreshed key", err)
+		}
+	}
+
+	err := bitsplit.RefreshIntoFiles(nil, keyFiles, newFiles)
+	if err != nil {
+		removeNew()
+		errorFatal("while refreshing", err)
+	}
+	for i, name := range keyFileNames {
+		errorFatal("while writing refreshed key", newFiles[i].Close())
+		keyFiles[i].Close()
+		errorFatal("while replacing key", os.Rename(newFiles[i].Name(), name))
+	}
+}
+
+func DoReshare(args []string) {
+	reshareMode := flag.NewFlagSet("reshare", flag.ExitOnError)
+	reshareKeyCount := reshareMode.Int("k", 2, "the number of new summons")
+	reshareThreshold := reshareMode.Int("t", 0,
+		"the number of new summons enough to restore the file, by default all of them are required")
+	reshareScheme := reshareMode.String("scheme", "",
+		"splitting scheme of the new summons: "+strings.Join(bitsplit.SchemeNames(), ", ")+
+			". By default shamir with -t and additive without")
+	reshareOut := reshareMode.String("out", "reshared", "new summons are named (out).key0, (out).key1 and so on")
+	reshareForce := reshareMode.Bool("f", false, "force rewriting new summons")
+
+	reshareMode.Parse(args)
+	keyFileNames := reshareMode.Args()
+	if len(keyFileNames) < 2 {
+		errLog.Fatal("usage: reshare (flags) (key files)")
+	}
+
+	threshold := *reshareThreshold
+	schemeName := *reshareScheme
+	if threshold == 0 {
+		threshold = *reshareKeyCount
+	}
+	if schemeName == "" {
+		schemeName = "additive"
+		if *reshareThreshold > 0 {
+			schemeName = "shamir"
+		}
+	}
+	scheme, err := bitsplit.GetScheme(schemeName)
+	errorFatal("", err)
+
+	keyFiles := make([]*os.File, len(keyFileNames))
+	for i, name := range keyFileNames {
+		keyFiles[i], err = os.Open(name)
+		errorFatal("while opening key", err)
+		defer keyFiles[i].Close()
+	}
+
+	newFiles := make([]*os.File, *reshareKeyCount)
+	for i := range newFiles {
+		name := fmt.Sprintf("%s.key%d", *reshareOut, i)
+		if !*reshareForce && osutil.FileExists(name) {
+			askForRewrite(name)
+		}
+		newFiles[i], err = os.Create(name)
+		errorFatal("while creating new key", err)
+	}
+
+	err = bitsplit.ReshareIntoFiles(scheme, nil, keyFiles, newFiles, threshold)
+	for _, f := range newFiles {
+		f.Close()
+		// incomplete keys are of no use
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}
+	errorFatal("while resharing", err)
+}
+
+func DoEnroll(args []string) {
+	enrollMode := flag.NewFlagSet("enroll", flag.ExitOnError)
+	enrollIndex := enrollMode.Int("index", 0,
+		"share index of the new summon, required. It must differ from the index of every summon given out before")
+	enrollOut := enrollMode.String("out", "enrolled.key", "name of the new summon")
+	enrollForce := enrollMode.Bool("f", false, "force rewriting the new summon")
+
+	enrollMode.Parse(args)
+	keyFileNames := enrollMode.Args()
+	if len(keyFileNames) < 2 {
+		errLog.Fatal("usage: enroll (flags) (key files)")
+	}
+	if !osutil.IsFlagPassedInSet(enrollMode, "index") {
+		errLog.Fatal("no share index given, use -index")
+	}
+
+	keyFiles := make([]*os.File, len(keyFileNames))
+	for i, name := range keyFileNames {
+		var err error
+		keyFiles[i], err = os.Open(name)
+		errorFatal("while opening key", err)
+		defer keyFiles[i].Close()
+	}
+
+	if !*enrollForce && osutil.FileExists(*enrollOut) {
+		askForRewrite(*enrollOut)
+	}
+	newFile, err := os.Create(*enrollOut)
+	errorFatal("while creating new key", err)
+
+	err = bitsplit.EnrollFromFiles(keyFiles, newFile, *enrollIndex)
+	newFile.Close()
+	if err != nil {
+		os.Remove(newFile.Name())
+	}
+	errorFatal("while enrolling", err)
+}
+
+func DoRepairShare(args []string) {
+	repairMode := flag.NewFlagSet("repair-share", flag.ExitOnError)
+	repairMode.Parse(args)
+	keyFileNames := repairMode.Args()
+	if len(keyFileNames) == 0 {
+		errLog.Fatal("usage: repair-share (key files)")
+	}
+
+	// the repaired key is written next to the damaged one and replaces it once it is written
+	for _, name := range keyFileNames {
+		keyFile, err := os.Open(name)
+		errorFatal("while opening key", err)
+		newFile, err := os.Create(name + ".repair")
+		if err != nil {
+			keyFile.Close()
+			errorFatal("while creating repaired key", err)
+		}
 
-	aesEncMode.Parse(args)
-	aesEncTail := aesEncMode.Args()
+		corrected, err := bitsplit.RepairShare(keyFile, newFile)
+		keyFile.Close()
+		newFile.Close()
+		if err != nil {
+			os.Remove(newFile.Name())
+			errorFatal(name, err)
+		}
+		errorFatal("while replacing key", os.Rename(newFile.Name(), name))
+		stdLog.Printf("%s: %d damaged bytes corrected\n", name, corrected)
+	}
+}
+
+func DoVerifyShare(args []string) {
+	if len(args) < 2 {
+		errLog.Fatal("usage: verify-share (commitments file) (key files)")
+	}
+	commitments, err := ioutil.ReadFile(args[0])
+	errorFatal("while reading commitments", err)
+
+	failed := false
+	for _, keyFileName := range args[1:] {
+		keyFile, err := os.Open(keyFileName)
+		errorFatal("while opening key", err)
+		err = bitsplit.VerifyShare(keyFile, bytes.NewReader(commitments))
+		_ = keyFile.Close()
+		if err != nil {
+			errLog.Printf("%s: %s\n", keyFileName, err)
+			failed = true
+			continue
+		}
+		stdLog.Printf("%s: ok\n", keyFileName)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func DoEncrypt(c bitsplit.Cipher, args []string) {
+	encMode := flag.NewFlagSet("encrypt-"+c.Name(), flag.ExitOnError)
+	encKey := encMode.String("key", "", "key in hex format")
+	encRewrite := encMode.Bool("r", false, "use this flag to rewrite input file with encrypted data")
+	encForce := encMode.Bool("f", false, "use this flag to force rewriting")
+	encHex := encMode.Bool("hex", false, "use this flag to save key in hex representation")
+	encReuse := encMode.Bool("reuse-key", false,
+		"this flag uses key saved in <key file> if it exists. It does nothing when -key is specified")
+	encPassphrase := encMode.Bool("passphrase", false,
+		"use this flag to derive the key from a passphrase, it is asked for without echo. (key file) is not provided with this flag")
+	encPassphraseFd := encMode.Int("passphrase-fd", -1, "read the passphrase from this file descriptor instead of asking")
+	encKDF := encMode.String("kdf", "argon2id", "key derivation function for the passphrase, argon2id or scrypt")
+
+	encMode.Parse(args)
+	encTail := encMode.Args()
+	usePassphrase := *encPassphrase || osutil.IsFlagPassedInSet(encMode, "passphrase-fd")
 	var fileName, keyFileName, outputFileName string
 
 	// checking various conditions
-	if len(aesEncTail) < 1 {
+	if len(encTail) < 1 {
 		errLog.Fatal("no input file given")
 	}
-	if len(aesEncTail) < 2 {
-		if *aesEncRewrite {
-			errLog.Fatal("no key file given")
-		} else {
+	fileName, encTail = encTail[0], encTail[1:]
+
+	if *encRewrite {
+		outputFileName = fileName
+	} else {
+		if len(encTail) < 1 {
 			errLog.Fatal("no output file given")
 		}
-	}
-	if (!*aesEncRewrite) && len(aesEncTail) < 3 {
-		errLog.Fatal("no key file given")
+		outputFileName, encTail = encTail[0], encTail[1:]
 	}
 
-	fileName = aesEncTail[0]
-	if *aesEncRewrite {
-		keyFileName = aesEncTail[1]
-		outputFileName = fileName
-	} else {
-		outputFileName = aesEncTail[1]
-		keyFileName = aesEncTail[2]
+	if !usePassphrase {
+		if len(encTail) < 1 {
+			errLog.Fatal("no key file given")
+		}
+		keyFileName = encTail[0]
 	}
 
 	// encrypting
 	// getting the key
-	var key []byte
+	var key, passphrase []byte
 	var err error
-	if osutil.IsFlagPassed("key") {
-		key, err = hex.DecodeString(*aesEncKey)
-		errorFatal("invalid hex key", err)
-	} else if *aesEncReuse && osutil.FileExists(keyFileName){
-		key, err = ioutil.ReadFile(keyFileName)
-		errorFatal("while reading key file", err)
-
-		if *aesEncHex {
-			keyBuf := make([]byte, hex.DecodedLen(len(key)))
-			hex.Decode(keyBuf, key)
-			key = keyBuf
-		}
+	if usePassphrase {
+		passphrase = readPassphrase(*encPassphraseFd, true)
 	} else {
-		key = make([]byte, 32)
-		rand.Seed(bitsplit.GetSeed())
-		rand.Read(key)
-	}
-
-	// saving the key if needed
-	if !*aesEncReuse || !osutil.FileExists(keyFileName) { // we need to rewrite key only if we weren't said to reuse it or
-		if !*aesEncForce && osutil.FileExists(keyFileName) { // the file does not exist
-			askForRewrite(keyFileName)
+		if osutil.IsFlagPassedInSet(encMode, "key") {
+			key, err = hex.DecodeString(*encKey)
+			errorFatal("invalid hex key", err)
+		} else if *encReuse && osutil.FileExists(keyFileName) {
+			key, err = ioutil.ReadFile(keyFileName)
+			errorFatal("while reading key file", err)
+
+			if *encHex {
+				keyBuf := make([]byte, hex.DecodedLen(len(key)))
+				hex.Decode(keyBuf, key)
+				key = keyBuf
+			}
+		} else {
+			key, err = bitsplit.GenerateKey(nil, c.KeySize())
+			errorFatal("while generating key", err)
 		}
-		keyFile, err := os.Create(keyFileName)
-		errorFatal("while creating key file", err)
 
-		defer keyFile.Close()
+		// saving the key if needed
+		if !*encReuse || !osutil.FileExists(keyFileName) { // we need to rewrite key only if we weren't said to reuse it or
+			if !*encForce && osutil.FileExists(keyFileName) { // the file does not exist
+				askForRewrite(keyFileName)
+			}
+			keyFile, err := os.Create(keyFileName)
+			errorFatal("while creating key file", err)
 
-		if *aesEncHex {
-			hexString := hex.EncodeToString(key)
-			fmt.Fprint(keyFile, hexString)
-		} else {
-			keyFile.Write(key)
+			defer keyFile.Close()
+
+			if *encHex {
+				hexString := hex.EncodeToString(key)
+				fmt.Fprint(keyFile, hexString)
+			} else {
+				keyFile.Write(key)
+			}
 		}
 	}
 
+	var kdf bitsplit.KDF
+	if usePassphrase {
+		kdf, err = bitsplit.ParseKDF(*encKDF)
+		errorFatal("invalid -kdf", err)
+	}
+
 	// getting encrypted data
 	file, err := os.Open(fileName)
 	errorFatal("while opening input file", err)
-
-	var buf bytes.Buffer
-	err = bitsplit.AesGCMEncrypt(file, &buf, key)
-	errorFatal("while encrypting", err)
-	file.Close()
-
-	// writing encrypted data
-	if !*aesEncForce && !*aesEncRewrite && osutil.FileExists(outputFileName) {
+	if !*encForce && !*encRewrite && osutil.FileExists(outputFileName) {
 		askForRewrite(outputFileName)
 	}
-	file, err = os.Create(outputFileName)
-	errorFatal("while writing file", err)
-
-	_, err = file.Write(buf.Bytes())
-	errorFatal("writing encrypted text", err)
-	_ = file.Close()
+	// the output replaces the file only once it is complete, so with -r the input is never lost
+	output, err := createOutput(outputFileName)
+	errorFatal("while creating output file", err)
 
+	ctx, stop := osutil.InterruptContext()
+	input := bitsplit.NewProgressReader(ctx, file, osutil.ProgressBar("encrypting"))
+	if usePassphrase {
+		err = bitsplit.EncryptWithPassphrase(c, nil, input, output, passphrase, bitsplit.DefaultKDFParams(kdf))
+	} else {
+		err = c.Encrypt(nil, input, output, key)
+	}
+	exitIfInterrupted(ctx, err, output)
+	stop()
+	file.Close()
+	err = finishOutput(output, outputFileName, err)
+	errorFatal("while encrypting", err)
 }
 
-func DoDecryptAES(args []string) {
-	aesDecMode := flag.NewFlagSet("decrypt-aes", flag.ExitOnError)
-	aesDecKey := aesDecMode.String("key", "", "AES key in hex format")
-	aesDecForce := aesDecMode.Bool("f", false, "use this flag to force rewriting")
-	aesDecHex := aesDecMode.Bool("hex", false, "use this flag to load key, saved in hex representation")
-	aesDecRewrite := aesDecMode.Bool("r", false, "use this flag to rewrite file with decrypted data")
-
-	aesDecMode.Parse(args)
-	aesDecTail := aesDecMode.Args()
-	keyPassed := osutil.IsFlagPassed("key")
+// c is nil if the algorithm is to be taken from the header of the file
+func DoDecrypt(c bitsplit.Cipher, args []string) {
+	name := "decrypt"
+	if c != nil {
+		name += "-" + c.Name()
+	}
+	decMode := flag.NewFlagSet(name, flag.ExitOnError)
+	decKey := decMode.String("key", "", "key in hex format")
+	decForce := decMode.Bool("f", false, "use this flag to force rewriting")
+	decHex := decMode.Bool("hex", false, "use this flag to load key, saved in hex representation")
+	decRewrite := decMode.Bool("r", false, "use this flag to rewrite file with decrypted data")
+	decPassphrase := decMode.Bool("passphrase", false,
+		"use this flag to decrypt with a passphrase, it is asked for without echo. (key file) is not provided with this flag")
+	decPassphraseFd := decMode.Int("passphrase-fd", -1, "read the passphrase from this file descriptor instead of asking")
+
+	decMode.Parse(args)
+	decTail := decMode.Args()
+	usePassphrase := *decPassphrase || osutil.IsFlagPassedInSet(decMode, "passphrase-fd")
+	keyPassed := osutil.IsFlagPassedInSet(decMode, "key") || usePassphrase
 
 	var fileName, outputFileName, keyFileName string
 
-	if len(aesDecTail) < 1 {
+	if len(decTail) < 1 {
 		errLog.Fatal("no input file given")
 	}
-	fileName, aesDecTail = aesDecTail[0], aesDecTail[1:]
+	fileName, decTail = decTail[0], decTail[1:]
 
-	if !*aesDecRewrite && len(aesDecTail) < 1 {
+	if !*decRewrite && len(decTail) < 1 {
 		errLog.Fatal("no output file given")
 	}
-	if !*aesDecRewrite {
-		outputFileName, aesDecTail = aesDecTail[0], aesDecTail[1:]
+	if !*decRewrite {
+		outputFileName, decTail = decTail[0], decTail[1:]
 	} else {
 		outputFileName = fileName
 	}
 
-	if !keyPassed && len(aesDecTail) < 1 {
+	if !keyPassed && len(decTail) < 1 {
 		errLog.Fatal("no key file given")
 	}
 	if !keyPassed {
-		keyFileName = aesDecTail[0]
+		keyFileName = decTail[0]
 	}
 
-	var key []byte
+	var key, passphrase []byte
 	var err error
-	if keyPassed {
-		key, err = hex.DecodeString(*aesDecKey)
+	if usePassphrase {
+		passphrase = readPassphrase(*decPassphraseFd, false)
+	} else if keyPassed {
+		key, err = hex.DecodeString(*decKey)
 		errorFatal("while decoding key", err)
 	} else {
 		key, err = ioutil.ReadFile(keyFileName)
 		errorFatal("while reading key", err)
 
-		if *aesDecHex {
+		if *decHex {
 			keyBuf := make([]byte, hex.DecodedLen(len(key)))
 			_, err := hex.Decode(keyBuf, key)
 			key = keyBuf
@@ -343,21 +800,28 @@ func DoDecryptAES(args []string) {
 
 	file, err := os.Open(fileName)
 	errorFatal("while opening input file", err)
-
-	var buf bytes.Buffer
-	err = bitsplit.AesGCMDecrypt(file, &buf, key)
-	errorFatal("while decrypting", err)
-
-	_ = file.Close()
-	if !*aesDecForce && !*aesDecRewrite && osutil.FileExists(outputFileName) {
+	if !*decForce && !*decRewrite && osutil.FileExists(outputFileName) {
 		askForRewrite(outputFileName)
 	}
-	file, err = os.Create(outputFileName)
+	// segments are written as they are authenticated, the output replaces the file only
+	// if all of them are, so a truncated or altered file never leaves a partial output
+	output, err := createOutput(outputFileName)
 	errorFatal("while creating output file", err)
 
-	_, err = file.Write(buf.Bytes())
-	errorFatal("while writing to output", err)
-	_ = file.Close()
+	ctx, stop := osutil.InterruptContext()
+	input := bitsplit.NewProgressReader(ctx, file, osutil.ProgressBar("decrypting"))
+	if usePassphrase {
+		err = bitsplit.DecryptWithPassphrase(c, input, output, passphrase)
+	} else if c == nil {
+		err = bitsplit.Decrypt(input, output, key)
+	} else {
+		err = c.Decrypt(input, output, key)
+	}
+	exitIfInterrupted(ctx, err, output)
+	stop()
+	file.Close()
+	err = finishOutput(output, outputFileName, err)
+	errorFatal("while decrypting", err)
 }
 
 func DoKeygen(args []string) {
@@ -377,15 +841,15 @@ func DoKeygen(args []string) {
 	if osutil.FileExists(keyFileName) && !*keygenForce {
 		askForRewrite(keyFileName)
 	}
-	key := make([]byte, *keyLength)
-	rand.Seed(bitsplit.GetSeed())
-	rand.Read(key)
+	key, err := bitsplit.GenerateKey(nil, *keyLength)
+	errorFatal("while generating key", err)
+	stdLog.Printf("key fingerprint %s\n", bitsplit.KeyFingerprint(key))
 	if *keygenHex {
 		keyHex := make([]byte, hex.EncodedLen(len(key)))
 		hex.Encode(keyHex, key)
 		key = keyHex
 	}
-	err := ioutil.WriteFile(keyFileName, key, 0644)
+	err = ioutil.WriteFile(keyFileName, key, 0644)
 	errorFatal("couldn't write key", err)
 }
 
@@ -401,33 +865,33 @@ func RunCommandLine() {
 	case "split": DoSplit(os.Args[2:])
 	case "join":  DoJoin(os.Args[2:])
 	case "keygen": DoKeygen(os.Args[2:])
+	case "verify-share": DoVerifyShare(os.Args[2:])
+	case "refresh": DoRefresh(os.Args[2:])
+	case "reshare": DoReshare(os.Args[2:])
+	case "enroll": DoEnroll(os.Args[2:])
+	case "repair-share": DoRepairShare(os.Args[2:])
 
 	case "encrypt":
 		if len(os.Args) == 2 {
 			errLog.Fatal("encryption algorithm is not specified")
 		}
-
-		switch os.Args[2] {
-
-		case "aes": DoEncryptAES(os.Args[3:])
-
-		default:
-			errLog.Fatal("unknown encryption type")
+		c, err := bitsplit.GetCipher(os.Args[2])
+		if err != nil {
+			errLog.Fatalf("unknown encryption type %s, use one of %s", os.Args[2], strings.Join(bitsplit.CipherNames(), ", "))
 		}
+		DoEncrypt(c, os.Args[3:])
 
 	case "decrypt":
-		if len(os.Args) == 2 {
-			errLog.Fatal("decryption algorithm is not specified")
-		}
-
-		switch os.Args[2] {
-
-		case "aes": DoDecryptAES(os.Args[3:])
-
-		default:
-			errLog.Fatal("unknown decryption type")
+		// the algorithm is optional, encrypted files name it in their header
+		var c bitsplit.Cipher
+		args := os.Args[2:]
+		if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+			named, err := bitsplit.GetCipher(args[0])
+			if err == nil {
+				c, args = named, args[1:]
+			}
 		}
-
+		DoDecrypt(c, args)
 
 	default:
 		stdLog.Printf("Unknown command %s. Visit github.com/imobulus/bitsplit or use --help for help\n", os.Args[1])
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
 	"flag"
@@ -10,13 +11,15 @@ import (
 	"github.com/imobulus/bitsplit/osutil"
 	"io/ioutil"
 	"log"
-	"math/rand"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 const (
 	LockFileName = ".lock"
+	// second line of the lock file, files are encrypted with their relative paths as associated data
+	LockBindPaths = "bind-paths"
 	CodeSuccess = 0
 	CodeLockDirNotExist = 1
 	CodeKeyDirNotExist = 2
@@ -38,8 +41,35 @@ func errorFatal(message string, err error) {
 	}
 }
 
+// relative path of the file inside the locked directory, the same on every OS,
+// so swapped or moved files fail to decrypt
+func pathAD(path string) []byte {
+	return []byte(filepath.ToSlash(path))
+}
+
+// total size of the files in the directory tree
+func dirSize(dir string) (int64, error) {
+	size := int64(0)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
 // returns a non-zero code and error if some directory not exists, otherwise kills the program. Fix in future
 func Lock(lockDir, keyDir string) (int, error) {
+	return LockContext(context.Background(), lockDir, keyDir, nil)
+}
+
+// Lock that can be cancelled, then the directory is restored like on any other error.
+// The progress is of the bytes of all the files encrypted, progress may be nil
+func LockContext(ctx context.Context, lockDir, keyDir string, progress bitsplit.Progress) (int, error) {
 	if !osutil.DirExists(lockDir) {
 		return CodeLockDirNotExist, fmt.Errorf("can't find directory %s", lockDir)
 		}
@@ -87,9 +117,14 @@ func Lock(lockDir, keyDir string) (int, error) {
 	}
 
 	h := sha1.New()
-	key := make([]byte, 32)
-	rand.Seed(bitsplit.GetSeed())
-	rand.Read(key)
+	key, err := bitsplit.GenerateKey(nil, 32)
+	if err != nil {
+		err1 := os.RemoveAll(tempDir)
+		errorFatal(
+			fmt.Sprintf(
+				"can't remove temporary directory %s while aborting. Please remove manually", tempDir), err1)
+		errLog.Fatal("can't generate key\n" + err.Error())
+	}
 	h.Write(key)
 
 	hash := hex.EncodeToString(h.Sum(nil))
@@ -136,6 +171,10 @@ func Lock(lockDir, keyDir string) (int, error) {
 
 	abortIfError( ioutil.WriteFile(filepath.Join(keyDir, hash), key, 0644), "while writing key" )
 
+	total, err := dirSize(".")
+	abortIfError(err, "while walking file tree")
+	done := int64(0)
+
     //encrypting
 	err = filepath.Walk(".", func (path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -144,6 +183,9 @@ func Lock(lockDir, keyDir string) (int, error) {
 		if info.IsDir() {
 			return nil
 		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 
 		file, err := os.Open(path)
 		if err != nil {
@@ -161,7 +203,8 @@ func Lock(lockDir, keyDir string) (int, error) {
 		h.Write(fileContents)
 
 		var buf bytes.Buffer
-		err = bitsplit.AesGCMEncrypt(bytes.NewReader(fileContents), &buf, key)
+		err = bitsplit.AesGCMEncryptWithADContext(ctx, nil, bytes.NewReader(fileContents), &buf, key, pathAD(path),
+			fileProgress(progress, done, total))
 		if err != nil {
 			return bitsplit.OSError{Details: fmt.Sprintf("can't encrypt %s", path), Contents: err}
 		}
@@ -170,6 +213,7 @@ func Lock(lockDir, keyDir string) (int, error) {
 		if err != nil {
 			return bitsplit.OSError{Details: fmt.Sprintf("can't write to %s", path), Contents: err}
 		}
+		done += info.Size()
 
 		return nil
 	})
@@ -178,7 +222,7 @@ func Lock(lockDir, keyDir string) (int, error) {
 
 	abortIfError( osutil.HideFile(filepath.Join(keyDir, hash)), "can't hide key file" )
 
-	abortIfError( ioutil.WriteFile(LockFileName, []byte(hash), 0644), "can't write key file" )
+	abortIfError( ioutil.WriteFile(LockFileName, []byte(hash + "\n" + LockBindPaths), 0644), "can't write key file" )
 
 	abortIfError( osutil.HideFile(LockFileName), "can't hide lock file" )
 
@@ -201,6 +245,12 @@ func Lock(lockDir, keyDir string) (int, error) {
 
 // returns a non-zero code and error if some directory not exists, otherwise kills the program. Fix in future
 func Unlock(unlockDir, keyDir string) (int, error) {
+	return UnlockContext(context.Background(), unlockDir, keyDir, nil)
+}
+
+// Unlock that can be cancelled, then the directory is restored like on any other error.
+// The progress is of the bytes of all the encrypted files, progress may be nil
+func UnlockContext(ctx context.Context, unlockDir, keyDir string, progress bitsplit.Progress) (int, error) {
 	if !osutil.DirExists(unlockDir) {
 		return CodeLockDirNotExist, fmt.Errorf("can't find directory %s", unlockDir)
 	}
@@ -222,7 +272,10 @@ func Unlock(unlockDir, keyDir string) (int, error) {
 	if err != nil {
 		errLog.Fatalf("can't read lock file %s", LockFileName)
 	}
-	keyFileName := string(keyFileBytes)
+	// lock files of older versions hold only the key file name, their files are not bound to paths
+	lockLines := strings.SplitN(string(keyFileBytes), "\n", 2)
+	keyFileName := lockLines[0]
+	bindPaths := len(lockLines) == 2 && strings.TrimSpace(lockLines[1]) == LockBindPaths
 
 	key, err := ioutil.ReadFile(filepath.Join(keyDir, keyFileName))
 	if err != nil {
@@ -284,6 +337,10 @@ func Unlock(unlockDir, keyDir string) (int, error) {
 	abortIfError( os.Chmod(LockFileName, 0222), "can't make lock file writable" )
 	abortIfError( os.Remove(LockFileName), "can't remove lock file" )
 
+	total, err := dirSize(".")
+	abortIfError(err, "while walking file tree")
+	done := int64(0)
+
 	//decrypting
 	err = filepath.Walk(".", func (path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -292,6 +349,9 @@ func Unlock(unlockDir, keyDir string) (int, error) {
 		if info.IsDir() {
 			return nil
 		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 
 		var buf bytes.Buffer
 		file, err := os.Open(path)
@@ -299,7 +359,11 @@ func Unlock(unlockDir, keyDir string) (int, error) {
 			return bitsplit.OSError{Details: fmt.Sprintf("can't open %s", path), Contents: err}
 		}
 
-		err = bitsplit.AesGCMDecrypt(file, &buf, key)
+		var ad []byte
+		if bindPaths {
+			ad = pathAD(path)
+		}
+		err = bitsplit.AesGCMDecryptWithADContext(ctx, file, &buf, key, ad, fileProgress(progress, done, total))
 		if err != nil {
 			return bitsplit.OSError{Details: fmt.Sprintf("can't decrypt %s", path), Contents: err}
 		}
@@ -312,6 +376,7 @@ func Unlock(unlockDir, keyDir string) (int, error) {
 		if err != nil {
 			return bitsplit.OSError{Details: fmt.Sprintf("can't write to %s", path), Contents: err}
 		}
+		done += info.Size()
 
 		return nil
 	})
@@ -329,6 +394,16 @@ func Unlock(unlockDir, keyDir string) (int, error) {
 	return CodeSuccess, nil
 }
 
+// progress of a file reported as the progress of the whole directory, done bytes are of the files before it
+func fileProgress(progress bitsplit.Progress, done, total int64) bitsplit.Progress {
+	if progress == nil {
+		return nil
+	}
+	return func(fileDone, _ int64) {
+		progress(done+fileDone, total)
+	}
+}
+
 func runCommandLine() {
 	if len(os.Args) < 2 {
 		stdLog.Println("this is directory locker")
@@ -339,6 +414,10 @@ func runCommandLine() {
 	lockKeyDir := lock.String("keydir", "", "specify key directory")
 	lockDir := lock.String("dir", ".", "specify lock directory")
 
+	// the directory is restored on interrupt
+	ctx, stop := osutil.InterruptContext()
+	defer stop()
+
 	switch os.Args[1] {
 	case "lock":
 		err := lock.Parse(os.Args[2:])
@@ -354,14 +433,14 @@ func runCommandLine() {
 			*lockKeyDir = drives[len(drives) - 1]
 		}
 
-		_, err = Lock(*lockDir, *lockKeyDir)
+		_, err = LockContext(ctx, *lockDir, *lockKeyDir, osutil.ProgressBar("locking"))
 		errorFatal("", err)
 	case "unlock":
 		err := lock.Parse(os.Args[2:])
 		errorFatal("can't parse flags", err)
 		if !osutil.IsFlagPassedInSet(lock,"keydir") {
 			for _, d := range osutil.GetDrives() {
-				code, err := Unlock(*lockDir, d)
+				code, err := UnlockContext(ctx, *lockDir, d, osutil.ProgressBar("unlocking"))
 				switch code {
 				case CodeSuccess:
 					os.Exit(0)
@@ -373,7 +452,7 @@ func runCommandLine() {
 			}
 			errLog.Fatal("can't find external key. Specify key directory using -keydir")
 		} else {
-			_, err := Unlock(*lockDir, *lockKeyDir)
+			_, err := UnlockContext(ctx, *lockDir, *lockKeyDir, osutil.ProgressBar("unlocking"))
 			errorFatal("", err)
 		}
 	}
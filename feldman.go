@@ -0,0 +1,305 @@
+package bitsplit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+)
+
+// Feldman's verifiable secret sharing. Like in SplitHybrid the file is encrypted with a random key,
+// and the key is shared with Shamir's scheme over the integers mod q, where p = 2q + 1 is the
+// 2048-bit MODP prime of RFC 3526 (group 14) and g = 2 generates the subgroup of order q.
+// The commitments C_j = g^a_j mod p to the coefficients of the polynomial f are published with the set,
+// so every share can be checked on its own: g^f(i) = C_0 * C_1^i * ... * C_k-1^(i^(k-1)) mod p
+//   commitments file: magic "BSVC", format version, split set id, threshold (uint16), the commitments
+//   share payload:    f(i)
+// All numbers are big endian, the ones mod p and q take 256 bytes each
+
+const feldmanNumberSize = 256
+
+// the commitments file has its own format version, files stamped with version 2 of the shares are read too
+const feldmanFormatVersion = 1
+
+var (
+	feldmanP, _ = new(big.Int).SetString("FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD1"+
+		"29024E088A67CC74020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F1437"+
+		"4FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7EDEE386BFB5A899FA5"+
+		"AE9F24117C4B1FE649286651ECE45B3DC2007CB8A163BF0598DA48361C55D39A69163FA8FD24CF5F"+
+		"83655D23DCA3AD961C62F356208552BB9ED529077096966D670C354E4ABC9804F1746C08CA18217C"+
+		"32905E462E36CE3BE39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9DE2BCBF695581718"+
+		"3995497CEA956AE515D2261898FA051015728E5A8AACAA68FFFFFFFFFFFFFFFF", 16)
+	feldmanQ = new(big.Int).Rsh(feldmanP, 1)
+	feldmanG = big.NewInt(2)
+
+	commitmentsMagic = []byte("BSVC")
+)
+
+//---- commitments ----
+
+type feldmanCommitments struct {
+	SetID  setID
+	values []*big.Int
+}
+
+func (c feldmanCommitments) marshal() []byte {
+	b := make([]byte, 4+1+16+2, 4+1+16+2+len(c.values)*feldmanNumberSize)
+	copy(b, commitmentsMagic)
+	b[4] = feldmanFormatVersion
+	copy(b[5:21], c.SetID[:])
+	binary.BigEndian.PutUint16(b[21:], uint16(len(c.values)))
+	for _, v := range c.values {
+		b = append(b, v.FillBytes(make([]byte, feldmanNumberSize))...)
+	}
+	return b
+}
+
+func readFeldmanCommitments(r io.Reader) (feldmanCommitments, error) {
+	var c feldmanCommitments
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return c, IOError{"while reading commitments", err}
+	}
+	if len(b) < 4+1+16+2 || !bytes.Equal(b[:4], commitmentsMagic) {
+		return c, fmt.Errorf("not a commitments file")
+	}
+	if b[4] != feldmanFormatVersion && b[4] != 2 {
+		return c, fmt.Errorf("unsupported commitments format version %d", b[4])
+	}
+	copy(c.SetID[:], b[5:21])
+	k := int(binary.BigEndian.Uint16(b[21:]))
+	b = b[23:]
+	if k < 2 || len(b) != k*feldmanNumberSize {
+		return c, fmt.Errorf("commitments file is truncated or damaged")
+	}
+
+	c.values = make([]*big.Int, k)
+	for j := range c.values {
+		c.values[j] = new(big.Int).SetBytes(b[j*feldmanNumberSize : (j+1)*feldmanNumberSize])
+		if c.values[j].Sign() == 0 || c.values[j].Cmp(feldmanP) >= 0 {
+			return c, fmt.Errorf("commitments file is damaged")
+		}
+	}
+	return c, nil
+}
+
+// checks the share with the given index against the commitments
+func (c feldmanCommitments) verify(index uint16, share *big.Int) bool {
+	expected := big.NewInt(1)
+	x, power := big.NewInt(int64(index)), big.NewInt(1)
+	term := new(big.Int)
+	for _, v := range c.values {
+		term.Exp(v, power, feldmanP)
+		expected.Mul(expected, term).Mod(expected, feldmanP)
+		power.Mul(power, x).Mod(power, feldmanQ)
+	}
+	return new(big.Int).Exp(feldmanG, share, feldmanP).Cmp(expected) == 0
+}
+
+//---- splitting ----
+
+// like SplitHybrid, but the commitments written to commitments let every key be verified with VerifyShare.
+// Any k of the keys restore the file
+func SplitVerifiable(random RandomSource, file io.Reader, ciphertext, commitments io.Writer,
+	keys []io.Writer, k int) error {
+	n := len(keys)
+	err := checkThreshold(n, k)
+	if err != nil {
+		return err
+	}
+
+	key, err := GenerateKey(random, hybridKeySize)
+	if err != nil {
+		return err
+	}
+	coefficients := make([]*big.Int, k)
+	coefficients[0] = new(big.Int).SetBytes(key)
+	for j := 1; j < k; j++ {
+		coefficients[j], err = randomInt(random, feldmanQ)
+		if err != nil {
+			return err
+		}
+	}
+
+	id, err := newSetID(random)
+	if err != nil {
+		return err
+	}
+	c := feldmanCommitments{SetID: id, values: make([]*big.Int, k)}
+	for j, a := range coefficients {
+		c.values[j] = new(big.Int).Exp(feldmanG, a, feldmanP)
+	}
+	_, err = commitments.Write(c.marshal())
+	if err != nil {
+		return IOError{"while writing commitments", err}
+	}
+
+	header := shareHeader{
+		Scheme:    SchemeFeldman,
+		Flags:     shareFlagHybrid,
+		SetID:     id,
+		Total:     uint16(n),
+		Threshold: uint16(k),
+	}
+	digest := sha256.Sum256(key)
+	for i, key := range keys {
+		header.Index = uint16(i + 1)
+		x := big.NewInt(int64(header.Index))
+		y := new(big.Int)
+		for j := k - 1; j >= 0; j-- {
+			y.Mul(y, x).Add(y, coefficients[j]).Mod(y, feldmanQ)
+		}
+
+		w, err := newShareWriter(key, header)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(y.FillBytes(make([]byte, feldmanNumberSize)))
+		if err != nil {
+			return err
+		}
+		w.digest = digest[:]
+		err = w.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return AesGCMEncryptWithRandom(random, file, ciphertext, key)
+}
+
+func SplitVerifiableIntoFiles(random RandomSource, file io.Reader, ciphertext, commitments *os.File,
+	keys []*os.File, k int) error {
+	keyWriters := make([]io.Writer, len(keys))
+	for i, key := range keys {
+		keyWriters[i] = key
+	}
+	return SplitVerifiable(random, file, ciphertext, commitments, keyWriters, k)
+}
+
+//---- verifying and joining ----
+
+func readFeldmanShare(key io.Reader) (shareHeader, *big.Int, error) {
+	r, err := newShareReader(key)
+	if err != nil {
+		return shareHeader{}, nil, err
+	}
+	if r.legacy {
+		return shareHeader{}, nil, fmt.Errorf("not a share file")
+	}
+	if r.Scheme != SchemeFeldman {
+		return r.shareHeader, nil, errorf(ErrShareMismatch, "key is split with %s, not verifiable", r.Scheme)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return r.shareHeader, nil, err
+	}
+	if len(b) != feldmanNumberSize {
+		return r.shareHeader, nil, errorf(ErrShareDamaged, "share has invalid length %d", len(b))
+	}
+	return r.shareHeader, new(big.Int).SetBytes(b), nil
+}
+
+func checkCommitments(h shareHeader, c feldmanCommitments) error {
+	if h.SetID != c.SetID {
+		return errorf(ErrShareMismatch, "key belongs to split set %s, but the commitments to %s", h.SetID, c.SetID)
+	}
+	if int(h.Threshold) != len(c.values) {
+		return errorf(ErrShareMismatch, "key has threshold %d, but there are %d commitments", h.Threshold, len(c.values))
+	}
+	return nil
+}
+
+// checks that the key made by SplitVerifiable is consistent with the published commitments
+func VerifyShare(key io.Reader, commitments io.Reader) error {
+	c, err := readFeldmanCommitments(commitments)
+	if err != nil {
+		return err
+	}
+	h, share, err := readFeldmanShare(key)
+	if err != nil {
+		return err
+	}
+	err = checkCommitments(h, c)
+	if err != nil {
+		return err
+	}
+	if share.Cmp(feldmanQ) >= 0 || !c.verify(h.Index, share) {
+		return errorf(ErrShareDamaged, "share %d doesn't match the commitments", h.Index)
+	}
+	return nil
+}
+
+// every key is verified, keys that don't match the commitments are reported and left out
+func JoinVerifiable(file io.Writer, ciphertext, commitments io.Reader, keys []io.Reader) error {
+	if len(keys) < 2 {
+		return errLessThanTwoKeys
+	}
+	c, err := readFeldmanCommitments(commitments)
+	if err != nil {
+		return err
+	}
+
+	headers := make([]shareHeader, len(keys))
+	shares := make([]*big.Int, len(keys))
+	for i, key := range keys {
+		headers[i], shares[i], err = readFeldmanShare(key)
+		if err != nil {
+			return fmt.Errorf("key %d: %w", i, err)
+		}
+		err = checkCommitments(headers[i], c)
+		if err != nil {
+			return fmt.Errorf("key %d: %w", i, err)
+		}
+	}
+	err = checkShareSet(headers)
+	if err != nil {
+		return err
+	}
+
+	var xs []*big.Int
+	var ys []*big.Int
+	for i, h := range headers {
+		if shares[i].Cmp(feldmanQ) >= 0 || !c.verify(h.Index, shares[i]) {
+			warnLog.Printf("key %d (share %d) doesn't match the commitments, it is left out", i, h.Index)
+			continue
+		}
+		xs = append(xs, big.NewInt(int64(h.Index)))
+		ys = append(ys, shares[i])
+	}
+	k := len(c.values)
+	if len(xs) < k {
+		return errorf(ErrTooFewShares, "only %d of the keys match the commitments, %d are required", len(xs), k)
+	}
+	xs, ys = xs[:k], ys[:k]
+
+	// lagrange interpolation at 0
+	secret := new(big.Int)
+	for i := range xs {
+		num, den := big.NewInt(1), big.NewInt(1)
+		for j := range xs {
+			if i == j {
+				continue
+			}
+			num.Mul(num, new(big.Int).Neg(xs[j])).Mod(num, feldmanQ)
+			den.Mul(den, new(big.Int).Sub(xs[i], xs[j])).Mod(den, feldmanQ)
+		}
+		term := num.Mul(num, den.ModInverse(den, feldmanQ))
+		secret.Add(secret, term.Mul(term, ys[i])).Mod(secret, feldmanQ)
+	}
+	if secret.BitLen() > 8*hybridKeySize {
+		return fmt.Errorf("restored key is invalid")
+	}
+	return AesGCMDecrypt(ciphertext, file, secret.FillBytes(make([]byte, hybridKeySize)))
+}
+
+func JoinVerifiableFromFiles(file io.Writer, ciphertext, commitments *os.File, keys []*os.File) error {
+	keyReaders := make([]io.Reader, len(keys))
+	for i, key := range keys {
+		keyReaders[i] = key
+	}
+	return JoinVerifiable(file, ciphertext, commitments, keyReaders)
+}
@@ -0,0 +1,141 @@
+package bitsplit
+
+import (
+	"bytes"
+	"io"
+	"math/big"
+	"testing"
+)
+
+type verifiableSplit struct {
+	ciphertext  []byte
+	commitments []byte
+	keys        [][]byte
+}
+
+func splitVerifiableToBuffers(t *testing.T, data []byte, n, k int) verifiableSplit {
+	var s verifiableSplit
+	var ciphertext, commitments bytes.Buffer
+	s.keys = splitToBuffers(t, n, func(keys []io.Writer) error {
+		return SplitVerifiable(nil, bytes.NewReader(data), &ciphertext, &commitments, keys, k)
+	})
+	s.ciphertext, s.commitments = ciphertext.Bytes(), commitments.Bytes()
+	return s
+}
+
+// a valid share file with the share value changed
+func alterFeldmanShare(t *testing.T, key []byte) []byte {
+	h, share, err := readFeldmanShare(bytes.NewReader(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	share.Add(share, big.NewInt(1))
+	var out bytes.Buffer
+	w, err := newShareWriter(&out, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(share.FillBytes(make([]byte, feldmanNumberSize)))
+	w.Close()
+	return out.Bytes()
+}
+
+func TestSplitVerifiable(t *testing.T) {
+	data := randomBytes(1000)
+	s := splitVerifiableToBuffers(t, data, 5, 3)
+	for i, key := range s.keys {
+		err := VerifyShare(bytes.NewReader(key), bytes.NewReader(s.commitments))
+		if err != nil {
+			t.Fatalf("key %d: %v", i, err)
+		}
+	}
+
+	var out bytes.Buffer
+	err := JoinVerifiable(&out, bytes.NewReader(s.ciphertext), bytes.NewReader(s.commitments),
+		shareReaders(s.keys[2:]...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("joined data differs")
+	}
+
+	err = JoinVerifiable(io.Discard, bytes.NewReader(s.ciphertext), bytes.NewReader(s.commitments),
+		shareReaders(s.keys[:2]...))
+	if err == nil {
+		t.Fatal("joined with less than k keys")
+	}
+}
+
+func TestVerifiableAlteredShare(t *testing.T) {
+	data := randomBytes(1000)
+	s := splitVerifiableToBuffers(t, data, 5, 3)
+	altered := alterFeldmanShare(t, s.keys[1])
+	err := VerifyShare(bytes.NewReader(altered), bytes.NewReader(s.commitments))
+	if err == nil {
+		t.Fatal("altered share verified")
+	}
+
+	// the altered key is left out
+	var out bytes.Buffer
+	keys := [][]byte{s.keys[0], altered, s.keys[2], s.keys[3]}
+	err = JoinVerifiable(&out, bytes.NewReader(s.ciphertext), bytes.NewReader(s.commitments),
+		shareReaders(keys...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("joined data differs")
+	}
+
+	keys = [][]byte{s.keys[0], altered, s.keys[2]}
+	err = JoinVerifiable(io.Discard, bytes.NewReader(s.ciphertext), bytes.NewReader(s.commitments),
+		shareReaders(keys...))
+	if err == nil {
+		t.Fatal("joined with less than k valid keys")
+	}
+}
+
+func TestVerifyShareOtherSplit(t *testing.T) {
+	s := splitVerifiableToBuffers(t, randomBytes(10), 3, 2)
+	other := splitVerifiableToBuffers(t, randomBytes(10), 3, 2)
+	err := VerifyShare(bytes.NewReader(s.keys[0]), bytes.NewReader(other.commitments))
+	if err == nil {
+		t.Fatal("key verified against commitments of another split")
+	}
+
+	shamir := splitToBuffers(t, 3, func(keys []io.Writer) error {
+		return SplitThreshold(nil, bytes.NewReader(randomBytes(10)), keys, 2)
+	})
+	err = VerifyShare(bytes.NewReader(shamir[0]), bytes.NewReader(s.commitments))
+	if err == nil {
+		t.Fatal("shamir key verified")
+	}
+}
+
+func TestFeldmanCommitmentsMarshal(t *testing.T) {
+	s := splitVerifiableToBuffers(t, randomBytes(10), 4, 3)
+	c, err := readFeldmanCommitments(bytes.NewReader(s.commitments))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.values) != 3 || !bytes.Equal(c.marshal(), s.commitments) {
+		t.Fatal("commitments differ after reading")
+	}
+	_, err = readFeldmanCommitments(bytes.NewReader(s.commitments[:len(s.commitments)-1]))
+	if err == nil {
+		t.Fatal("read truncated commitments")
+	}
+
+	if s.commitments[4] != feldmanFormatVersion {
+		t.Fatalf("commitments of format version %d", s.commitments[4])
+	}
+	for version, ok := range map[byte]bool{2: true, 3: false} {
+		b := append([]byte(nil), s.commitments...)
+		b[4] = version
+		_, err = readFeldmanCommitments(bytes.NewReader(b))
+		if (err == nil) != ok {
+			t.Fatalf("version %d: %v", version, err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package bitsplit
+
+// arithmetic in GF(2^8) with the AES reduction polynomial x^8 + x^4 + x^3 + x + 1
+// addition and subtraction are both xor
+
+var (
+	gfExp [510]byte
+	gfLog [256]byte
+)
+
+func init() {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		gfExp[i] = x
+		gfLog[x] = byte(i)
+		// multiply by the generator 3
+		hi := x & 0x80
+		x2 := x << 1
+		if hi != 0 {
+			x2 ^= 0x1b
+		}
+		x ^= x2
+	}
+	for i := 255; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+// b must not be zero
+func gfDiv(a, b byte) byte {
+	if b == 0 {
+		panic("bitsplit: division by zero in GF(256)")
+	}
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// x^n
+func gfPow(x byte, n int) byte {
+	y := byte(1)
+	for i := 0; i < n; i++ {
+		y = gfMul(y, x)
+	}
+	return y
+}
+
+// inverts a square matrix with gauss-jordan elimination, returns false if it is singular
+func gfInvert(m [][]byte) ([][]byte, bool) {
+	n := len(m)
+	a := make([][]byte, n)
+	inv := make([][]byte, n)
+	for i := range m {
+		a[i] = append([]byte(nil), m[i]...)
+		inv[i] = make([]byte, n)
+		inv[i][i] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := col
+		for pivot < n && a[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return nil, false
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		inv[col], inv[pivot] = inv[pivot], inv[col]
+
+		scale := gfDiv(1, a[col][col])
+		for j := 0; j < n; j++ {
+			a[col][j] = gfMul(a[col][j], scale)
+			inv[col][j] = gfMul(inv[col][j], scale)
+		}
+		for row := 0; row < n; row++ {
+			f := a[row][col]
+			if row == col || f == 0 {
+				continue
+			}
+			for j := 0; j < n; j++ {
+				a[row][j] ^= gfMul(f, a[col][j])
+				inv[row][j] ^= gfMul(f, inv[col][j])
+			}
+		}
+	}
+	return inv, true
+}
+
+// solves a x = b with gaussian elimination, free variables are set to zero.
+// Returns false if there is no solution
+func gfSolve(a [][]byte, b []byte) ([]byte, bool) {
+	rows, cols := len(a), len(a[0])
+	m := make([][]byte, rows)
+	for i := range a {
+		m[i] = append(append([]byte(nil), a[i]...), b[i])
+	}
+
+	pivots := make([]int, 0, cols)
+	row := 0
+	for col := 0; col < cols && row < rows; col++ {
+		pivot := row
+		for pivot < rows && m[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == rows {
+			continue
+		}
+		m[row], m[pivot] = m[pivot], m[row]
+
+		scale := gfDiv(1, m[row][col])
+		for j := range m[row] {
+			m[row][j] = gfMul(m[row][j], scale)
+		}
+		for r := 0; r < rows; r++ {
+			f := m[r][col]
+			if r == row || f == 0 {
+				continue
+			}
+			for j := range m[r] {
+				m[r][j] ^= gfMul(f, m[row][j])
+			}
+		}
+		pivots = append(pivots, col)
+		row++
+	}
+	for r := row; r < rows; r++ {
+		if m[r][cols] != 0 {
+			return nil, false
+		}
+	}
+
+	x := make([]byte, cols)
+	for r, col := range pivots {
+		x[col] = m[r][cols]
+	}
+	return x, true
+}
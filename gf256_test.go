@@ -0,0 +1,120 @@
+package bitsplit
+
+import "testing"
+
+func gfMatMul(a, b [][]byte) [][]byte {
+	c := make([][]byte, len(a))
+	for i := range a {
+		c[i] = make([]byte, len(b[0]))
+		for j := range b[0] {
+			for t := range b {
+				c[i][j] ^= gfMul(a[i][t], b[t][j])
+			}
+		}
+	}
+	return c
+}
+
+func isIdentity(m [][]byte) bool {
+	for i, row := range m {
+		for j, v := range row {
+			if i == j && v != 1 || i != j && v != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestGFMulDiv(t *testing.T) {
+	// the example of the AES specification
+	if got := gfMul(0x57, 0x83); got != 0xc1 {
+		t.Fatalf("0x57 * 0x83 = %#x, want 0xc1", got)
+	}
+	for a := 0; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			p := gfMul(byte(a), byte(b))
+			if p != gfMul(byte(b), byte(a)) {
+				t.Fatalf("%d * %d is not commutative", a, b)
+			}
+			if q := gfDiv(p, byte(b)); q != byte(a) {
+				t.Fatalf("%d * %d / %d = %d", a, b, b, q)
+			}
+		}
+	}
+}
+
+func TestGFPow(t *testing.T) {
+	for _, x := range []byte{0, 1, 2, 3, 0x57} {
+		y := byte(1)
+		for n := 0; n < 300; n++ {
+			if got := gfPow(x, n); got != y {
+				t.Fatalf("%d^%d = %d, want %d", x, n, got, y)
+			}
+			y = gfMul(y, x)
+		}
+	}
+}
+
+func TestGFInvert(t *testing.T) {
+	tests := []struct {
+		name     string
+		m        [][]byte
+		singular bool
+	}{
+		{"identity", [][]byte{{1, 0}, {0, 1}}, false},
+		{"one by one", [][]byte{{7}}, false},
+		{"vandermonde", vandermonde([]byte{1, 2, 3, 4}, 4), false},
+		{"needs pivoting", [][]byte{{0, 1, 0}, {1, 0, 0}, {0, 0, 5}}, false},
+		{"zero", [][]byte{{0}}, true},
+		{"zero row", [][]byte{{1, 2}, {0, 0}}, true},
+		{"equal rows", [][]byte{{3, 4, 5}, {1, 1, 1}, {3, 4, 5}}, true},
+		{"row sum", [][]byte{{1, 2, 3}, {4, 5, 6}, {1 ^ 4, 2 ^ 5, 3 ^ 6}}, true},
+		{"scaled row", [][]byte{{1, 2}, {gfMul(9, 1), gfMul(9, 2)}}, true},
+		{"repeated x", vandermonde([]byte{1, 2, 2}, 3), true},
+	}
+	for _, test := range tests {
+		inv, ok := gfInvert(test.m)
+		if ok == test.singular {
+			t.Errorf("%s: invertible %v, want %v", test.name, ok, !test.singular)
+			continue
+		}
+		if ok && (!isIdentity(gfMatMul(test.m, inv)) || !isIdentity(gfMatMul(inv, test.m))) {
+			t.Errorf("%s: the product with the inverse is not the identity", test.name)
+		}
+	}
+}
+
+func TestGFSolve(t *testing.T) {
+	tests := []struct {
+		name string
+		a    [][]byte
+		b    []byte
+		ok   bool
+	}{
+		{"unique", [][]byte{{1, 1}, {1, 2}}, []byte{3, 7}, true},
+		{"more equations", [][]byte{{1, 0}, {0, 1}, {1, 1}}, []byte{5, 6, 5 ^ 6}, true},
+		{"free variable", [][]byte{{1, 1, 0}, {0, 0, 1}}, []byte{4, 9}, true},
+		{"inconsistent", [][]byte{{1, 2}, {1, 2}}, []byte{1, 2}, false},
+		{"zero row", [][]byte{{0, 0}, {1, 1}}, []byte{1, 1}, false},
+	}
+	for _, test := range tests {
+		x, ok := gfSolve(test.a, test.b)
+		if ok != test.ok {
+			t.Errorf("%s: solvable %v, want %v", test.name, ok, test.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		for i, row := range test.a {
+			y := byte(0)
+			for j, c := range row {
+				y ^= gfMul(c, x[j])
+			}
+			if y != test.b[i] {
+				t.Errorf("%s: equation %d doesn't hold", test.name, i)
+			}
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package bitsplit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Hybrid splitting encrypts the file once with a random key and splits only the key,
+// so there is one ciphertext as large as the file and the key files are small.
+// The key files have the hybrid flag set, Join refuses them and JoinHybrid requires it
+
+const hybridKeySize = 32
+
+// k is the threshold as in SplitThreshold, if it equals the number of keys all of them are required
+func SplitHybrid(random RandomSource, file io.Reader, ciphertext io.Writer, keys []io.Writer, k int) error {
+	key, err := GenerateKey(random, hybridKeySize)
+	if err != nil {
+		return err
+	}
+
+	// the key is split first, so wrong parameters are refused before anything is encrypted
+	var s Scheme = shamirScheme{}
+	if k == len(keys) {
+		s = additiveScheme{}
+	}
+	err = splitScheme(s, random, bytes.NewReader(key), keys, k, shareFlagHybrid)
+	if err != nil {
+		return err
+	}
+	return AesGCMEncryptWithRandom(random, file, ciphertext, key)
+}
+
+func SplitHybridIntoFiles(random RandomSource, file io.Reader, ciphertext *os.File, keys []*os.File, k int) error {
+	keyWriters := make([]io.Writer, len(keys))
+	for i, key := range keys {
+		keyWriters[i] = key
+	}
+	return SplitHybrid(random, file, ciphertext, keyWriters, k)
+}
+
+// restores the key from the keys made by SplitHybrid and decrypts the ciphertext with it
+func JoinHybrid(file io.Writer, ciphertext io.Reader, keys []io.Reader) error {
+	if len(keys) < 2 {
+		return errLessThanTwoKeys
+	}
+
+	var key bytes.Buffer
+	err := joinStream(&key, keys, 0, shareFlagHybrid)
+	if err != nil {
+		return err
+	}
+	if key.Len() != hybridKeySize {
+		return fmt.Errorf("joined key is %d bytes long instead of %d", key.Len(), hybridKeySize)
+	}
+	return AesGCMDecrypt(ciphertext, file, key.Bytes())
+}
+
+func JoinHybridFromFiles(file io.Writer, ciphertext *os.File, keys []*os.File) error {
+	keyReaders := make([]io.Reader, len(keys))
+	for i, key := range keys {
+		keyReaders[i] = key
+	}
+	return JoinHybrid(file, ciphertext, keyReaders)
+}
@@ -0,0 +1,68 @@
+package bitsplit
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func splitHybridToBuffers(t *testing.T, data []byte, n, k int) ([]byte, [][]byte) {
+	t.Helper()
+	var ciphertext bytes.Buffer
+	keys := splitToBuffers(t, n, func(keys []io.Writer) error {
+		return SplitHybrid(nil, bytes.NewReader(data), &ciphertext, keys, k)
+	})
+	return ciphertext.Bytes(), keys
+}
+
+func TestSplitHybrid(t *testing.T) {
+	tests := []struct{ n, k int }{{2, 2}, {3, 3}, {3, 2}, {5, 3}}
+	data := randomBytes(segmentSize + 1)
+	for _, test := range tests {
+		ciphertext, keys := splitHybridToBuffers(t, data, test.n, test.k)
+		for i, key := range keys {
+			if len(key) > 200 {
+				t.Fatalf("n=%d k=%d: key %d is %d bytes long", test.n, test.k, i, len(key))
+			}
+		}
+		var out bytes.Buffer
+		err := JoinHybrid(&out, bytes.NewReader(ciphertext), shareReaders(keys[test.n-test.k:]...))
+		if err != nil {
+			t.Fatalf("n=%d k=%d: %v", test.n, test.k, err)
+		}
+		if !bytes.Equal(out.Bytes(), data) {
+			t.Fatalf("n=%d k=%d: joined data differs", test.n, test.k)
+		}
+	}
+}
+
+func TestHybridKeysAreNotMixedUp(t *testing.T) {
+	data := randomBytes(100)
+	ciphertext, hybridKeys := splitHybridToBuffers(t, data, 3, 2)
+	plainKeys := splitToBuffers(t, 3, func(keys []io.Writer) error {
+		return SplitThreshold(nil, bytes.NewReader(data), keys, 2)
+	})
+	if err := Join(io.Discard, shareReaders(hybridKeys...)); err == nil {
+		t.Fatal("Join joined hybrid keys")
+	}
+	if err := JoinHybrid(io.Discard, bytes.NewReader(ciphertext), shareReaders(plainKeys...)); err == nil {
+		t.Fatal("JoinHybrid joined keys of a plain split")
+	}
+
+	other, _ := splitHybridToBuffers(t, data, 3, 2)
+	if err := JoinHybrid(io.Discard, bytes.NewReader(other), shareReaders(hybridKeys...)); err == nil {
+		t.Fatal("JoinHybrid decrypted a file encrypted with another key")
+	}
+}
+
+func TestSplitHybridParams(t *testing.T) {
+	var ciphertext bytes.Buffer
+	keys := []io.Writer{io.Discard, io.Discard}
+	err := SplitHybrid(nil, bytes.NewReader(randomBytes(100)), &ciphertext, keys, 3)
+	if err == nil {
+		t.Fatal("split with a threshold above the number of keys")
+	}
+	if ciphertext.Len() != 0 {
+		t.Fatal("the file is encrypted before the parameters are checked")
+	}
+}
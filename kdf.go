@@ -0,0 +1,177 @@
+package bitsplit
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Keys derived from passphrases. The parameters and the salt are stored in the header of the
+// encrypted file, so the passphrase alone is enough to decrypt it
+
+type KDF uint8
+
+const (
+	KDFNone     KDF = 0
+	KDFArgon2id KDF = 1
+	KDFScrypt   KDF = 2
+
+	kdfSaltSize = 16
+)
+
+func (k KDF) String() string {
+	switch k {
+	case KDFNone:
+		return "none"
+	case KDFArgon2id:
+		return "argon2id"
+	case KDFScrypt:
+		return "scrypt"
+	}
+	return fmt.Sprintf("unknown kdf %d", uint8(k))
+}
+
+func ParseKDF(name string) (KDF, error) {
+	switch name {
+	case "argon2id", "argon2":
+		return KDFArgon2id, nil
+	case "scrypt":
+		return KDFScrypt, nil
+	}
+	return KDFNone, fmt.Errorf("unknown key derivation function %s", name)
+}
+
+type KDFParams struct {
+	KDF  KDF
+	Salt [kdfSaltSize]byte
+
+	// argon2id: number of passes, memory in KiB and parallelism
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+
+	// scrypt: N is 2^LogN
+	LogN uint8
+	R, P uint32
+}
+
+// recommended parameters, the salt is left empty
+func DefaultKDFParams(kdf KDF) KDFParams {
+	switch kdf {
+	case KDFArgon2id:
+		return KDFParams{KDF: kdf, Time: 3, Memory: 64 * 1024, Threads: 4}
+	case KDFScrypt:
+		return KDFParams{KDF: kdf, LogN: 15, R: 8, P: 1}
+	}
+	return KDFParams{KDF: kdf}
+}
+
+// the most memory a key derivation may take, 1 GiB
+const maxKDFMemory = 1 << 30
+
+// refuses parameters that would take forever or all the memory, they may come from a damaged header
+func (p KDFParams) check() error {
+	switch p.KDF {
+	case KDFNone:
+		return nil
+	case KDFArgon2id:
+		if p.Time < 1 || p.Time > 64 || p.Threads < 1 || p.Memory < 8*uint32(p.Threads) || uint64(p.Memory)*1024 > maxKDFMemory {
+			return fmt.Errorf("invalid argon2id parameters t=%d m=%d p=%d", p.Time, p.Memory, p.Threads)
+		}
+		return nil
+	case KDFScrypt:
+		// scrypt takes 128 * r * (N + p) bytes
+		if p.LogN < 1 || p.LogN > 24 || p.R < 1 || p.P < 1 || p.P > 64 ||
+			128*uint64(p.R)*(1<<p.LogN+uint64(p.P)) > maxKDFMemory {
+			return fmt.Errorf("invalid scrypt parameters logN=%d r=%d p=%d", p.LogN, p.R, p.P)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported key derivation function %s", p.KDF)
+}
+
+func (p KDFParams) DeriveKey(passphrase []byte, keySize int) ([]byte, error) {
+	err := p.check()
+	if err != nil {
+		return nil, err
+	}
+
+	switch p.KDF {
+	case KDFArgon2id:
+		return argon2.IDKey(passphrase, p.Salt[:], p.Time, p.Memory, p.Threads, uint32(keySize)), nil
+	case KDFScrypt:
+		key, err := scrypt.Key(passphrase, p.Salt[:], 1<<p.LogN, int(p.R), int(p.P), keySize)
+		if err != nil {
+			return nil, IOError{"while deriving key", err}
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("no key derivation function to derive the key with")
+}
+
+// kdf id, then for argon2id: time, memory (uint32), threads; for scrypt: logN, r, p (uint32);
+// then the salt
+func (p KDFParams) marshal() []byte {
+	b := []byte{byte(p.KDF)}
+	switch p.KDF {
+	case KDFArgon2id:
+		b = append(b, make([]byte, 9)...)
+		binary.BigEndian.PutUint32(b[1:], p.Time)
+		binary.BigEndian.PutUint32(b[5:], p.Memory)
+		b[9] = p.Threads
+	case KDFScrypt:
+		b = append(b, make([]byte, 9)...)
+		b[1] = p.LogN
+		binary.BigEndian.PutUint32(b[2:], p.R)
+		binary.BigEndian.PutUint32(b[6:], p.P)
+	default:
+		return b
+	}
+	return append(b, p.Salt[:]...)
+}
+
+// reads marshalled parameters and returns them along with the bytes read,
+// io.EOF and io.ErrUnexpectedEOF are returned as is
+func readKDFParams(r io.Reader) (KDFParams, []byte, error) {
+	var p KDFParams
+	readErr := func(err error) error {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return err
+		}
+		return IOError{"while reading key derivation parameters", err}
+	}
+
+	b := make([]byte, 1, 1+9+kdfSaltSize)
+	_, err := io.ReadFull(r, b)
+	if err != nil {
+		return p, nil, readErr(err)
+	}
+	p.KDF = KDF(b[0])
+	if p.KDF == KDFNone {
+		return p, b, nil
+	}
+	if p.KDF != KDFArgon2id && p.KDF != KDFScrypt {
+		return p, nil, fmt.Errorf("unsupported key derivation function %s", p.KDF)
+	}
+
+	b = b[:cap(b)]
+	_, err = io.ReadFull(r, b[1:])
+	if err != nil {
+		return p, nil, readErr(err)
+	}
+	switch p.KDF {
+	case KDFArgon2id:
+		p.Time = binary.BigEndian.Uint32(b[1:])
+		p.Memory = binary.BigEndian.Uint32(b[5:])
+		p.Threads = b[9]
+	case KDFScrypt:
+		p.LogN = b[1]
+		p.R = binary.BigEndian.Uint32(b[2:])
+		p.P = binary.BigEndian.Uint32(b[6:])
+	}
+	copy(p.Salt[:], b[10:])
+	return p, b, nil
+}
@@ -0,0 +1,125 @@
+package bitsplit
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// fast parameters for the tests
+var testKDFParams = []KDFParams{
+	{KDF: KDFArgon2id, Time: 1, Memory: 64, Threads: 1},
+	{KDF: KDFScrypt, LogN: 4, R: 8, P: 1},
+}
+
+func TestKDFParamsMarshal(t *testing.T) {
+	for _, p := range append(testKDFParams, KDFParams{}) {
+		copy(p.Salt[:], randomBytes(kdfSaltSize))
+		if p.KDF == KDFNone {
+			p.Salt = [kdfSaltSize]byte{}
+		}
+		b := p.marshal()
+		got, raw, err := readKDFParams(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("%s: %v", p.KDF, err)
+		}
+		if got != p || !bytes.Equal(raw, b) {
+			t.Fatalf("%s: got %+v, want %+v", p.KDF, got, p)
+		}
+		if len(b) > 1 {
+			_, _, err = readKDFParams(bytes.NewReader(b[:len(b)-1]))
+			if err != io.ErrUnexpectedEOF {
+				t.Fatalf("%s: truncated parameters give %v", p.KDF, err)
+			}
+		}
+	}
+}
+
+func TestKDFParamsCheck(t *testing.T) {
+	tests := []KDFParams{
+		{KDF: KDFArgon2id, Time: 0, Memory: 64, Threads: 1},
+		{KDF: KDFArgon2id, Time: 1, Memory: 64, Threads: 0},
+		{KDF: KDFArgon2id, Time: 1, Memory: 7, Threads: 1},
+		{KDF: KDFArgon2id, Time: 1, Memory: 1 << 31, Threads: 1},
+		{KDF: KDFScrypt, LogN: 0, R: 8, P: 1},
+		{KDF: KDFScrypt, LogN: 40, R: 8, P: 1},
+		{KDF: KDFScrypt, LogN: 4, R: 0, P: 1},
+		{KDF: KDF(9)},
+	}
+	for _, p := range tests {
+		if _, err := p.DeriveKey([]byte("pass"), 32); err == nil {
+			t.Errorf("%+v: derived a key", p)
+		}
+	}
+}
+
+func TestKDFMemoryCap(t *testing.T) {
+	tests := []struct {
+		p  KDFParams
+		ok bool
+	}{
+		{KDFParams{KDF: KDFArgon2id, Time: 1, Memory: 1 << 20, Threads: 4}, true},
+		{KDFParams{KDF: KDFArgon2id, Time: 1, Memory: 1<<20 + 1, Threads: 4}, false},
+		{KDFParams{KDF: KDFScrypt, LogN: 19, R: 8, P: 1}, true},
+		{KDFParams{KDF: KDFScrypt, LogN: 20, R: 8, P: 1}, false},
+		{KDFParams{KDF: KDFScrypt, LogN: 4, R: 1 << 20, P: 1}, false},
+		{KDFParams{KDF: KDFScrypt, LogN: 4, R: 8, P: 65}, false},
+	}
+	for _, test := range tests {
+		if err := test.p.check(); (err == nil) != test.ok {
+			t.Errorf("%+v: %v", test.p, err)
+		}
+	}
+}
+
+func TestDeriveKey(t *testing.T) {
+	for _, p := range testKDFParams {
+		a, err := p.DeriveKey([]byte("pass"), 32)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := p.DeriveKey([]byte("pass"), 32)
+		c, _ := p.DeriveKey([]byte("word"), 32)
+		p.Salt[0] ^= 1
+		d, _ := p.DeriveKey([]byte("pass"), 32)
+		if len(a) != 32 || !bytes.Equal(a, b) || bytes.Equal(a, c) || bytes.Equal(a, d) {
+			t.Fatalf("%s: derived keys %x %x %x %x", p.KDF, a, b, c, d)
+		}
+	}
+}
+
+func TestPassphrase(t *testing.T) {
+	data := randomBytes(segmentSize + 1)
+	for _, name := range CipherNames() {
+		c, _ := GetCipher(name)
+		for _, p := range testKDFParams {
+			var encrypted, out bytes.Buffer
+			err := EncryptWithPassphrase(c, nil, bytes.NewReader(data), &encrypted, []byte("pass"), p)
+			if err != nil {
+				t.Fatalf("%s %s: %v", name, p.KDF, err)
+			}
+			err = DecryptWithPassphrase(c, bytes.NewReader(encrypted.Bytes()), &out, []byte("pass"))
+			if err != nil {
+				t.Fatalf("%s %s: %v", name, p.KDF, err)
+			}
+			if !bytes.Equal(out.Bytes(), data) {
+				t.Fatalf("%s %s: decrypted data differs", name, p.KDF)
+			}
+			err = DecryptWithPassphrase(c, bytes.NewReader(encrypted.Bytes()), io.Discard, []byte("word"))
+			if err == nil {
+				t.Fatalf("%s %s: decrypted with a wrong passphrase", name, p.KDF)
+			}
+		}
+
+		// a file encrypted with a key has no parameters to derive one from
+		var encrypted bytes.Buffer
+		err := c.Encrypt(nil, bytes.NewReader(data), &encrypted, testKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = DecryptWithPassphrase(c, &encrypted, io.Discard, []byte("pass"))
+		if err == nil {
+			t.Fatalf("%s: decrypted a file encrypted with a key", name)
+		}
+	}
+}
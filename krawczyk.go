@@ -0,0 +1,299 @@
+package bitsplit
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Krawczyk's computational secret sharing: the file is encrypted with a random key,
+// the ciphertext is dispersed with Rabin's IDA and the key is shared with Shamir's scheme.
+// Every share is about 1/k of the file, so n shares take n/k times the file size.
+// The payload of a share is its Shamir share of the key followed by its part of the ciphertext.
+// Every k bytes of the ciphertext are the coefficients of a polynomial and a share gets its value
+// at the share index, k shares make a vandermonde system restoring the coefficients.
+// The ciphertext is padded to a multiple of k with p bytes of value p
+
+//---- dispersal helpers ----
+
+// rows of the vandermonde matrix for the share indices xs
+func vandermonde(xs []byte, k int) [][]byte {
+	m := make([][]byte, len(xs))
+	for i, x := range xs {
+		m[i] = make([]byte, k)
+		for j := range m[i] {
+			m[i][j] = gfPow(x, j)
+		}
+	}
+	return m
+}
+
+// disperses data, whose length is a multiple of len(matrix[0]), into the shares
+func disperse(matrix [][]byte, data []byte, shares [][]byte) {
+	k := len(matrix[0])
+	for i, s := range shares {
+		row := matrix[i]
+		for t := range s {
+			y := byte(0)
+			for j, c := range data[t*k : t*k+k] {
+				y ^= gfMul(row[j], c)
+			}
+			s[t] = y
+		}
+	}
+}
+
+// restores data from k shares, inverse is the inverse of their vandermonde matrix
+func recoverDispersed(inverse [][]byte, shares [][]byte, data []byte) {
+	k := len(inverse)
+	for t := range shares[0] {
+		for j := 0; j < k; j++ {
+			y := byte(0)
+			for r, s := range shares {
+				y ^= gfMul(inverse[j][r], s[t])
+			}
+			data[t*k+j] = y
+		}
+	}
+}
+
+// holds back the last k bytes written and removes the padding from them on Close
+type unpadWriter struct {
+	w    io.Writer
+	k    int
+	held []byte
+}
+
+func (u *unpadWriter) Write(p []byte) (int, error) {
+	u.held = append(u.held, p...)
+	if len(u.held) > u.k {
+		n := len(u.held) - u.k
+		_, err := u.w.Write(u.held[:n])
+		if err != nil {
+			return 0, err
+		}
+		u.held = append(u.held[:0], u.held[n:]...)
+	}
+	return len(p), nil
+}
+
+func (u *unpadWriter) Close() error {
+	if len(u.held) == 0 {
+		return fmt.Errorf("dispersed data is truncated")
+	}
+	p := int(u.held[len(u.held)-1])
+	if p == 0 || p > u.k || p > len(u.held) {
+		return fmt.Errorf("dispersed data has invalid padding")
+	}
+	for _, b := range u.held[len(u.held)-p:] {
+		if int(b) != p {
+			return fmt.Errorf("dispersed data has invalid padding")
+		}
+	}
+	_, err := u.w.Write(u.held[:len(u.held)-p])
+	return err
+}
+
+//---- splitting and joining ----
+
+type krawczykScheme struct{}
+
+func (krawczykScheme) ID() SchemeID { return SchemeKrawczyk }
+func (krawczykScheme) Name() string { return "krawczyk" }
+func (krawczykScheme) CheckParams(n, k int) error {
+	return checkThreshold(n, k)
+}
+
+func (krawczykScheme) SplitStream(random RandomSource, file io.Reader, shares []io.Writer, k int) error {
+	n := len(shares)
+	key, err := GenerateKey(random, hybridKeySize)
+	if err != nil {
+		return err
+	}
+	keyShares := make([][]byte, n)
+	for i := range keyShares {
+		keyShares[i] = make([]byte, len(key))
+	}
+	err = shamirScheme{}.Split(random, key, keyShares, k)
+	if err != nil {
+		return err
+	}
+
+	xs := make([]byte, n)
+	for i, share := range shares {
+		xs[i] = byte(i + 1)
+		_, err = share.Write(keyShares[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	// the ciphertext is dispersed as it is encrypted
+	pr, pw := io.Pipe()
+	encrypted := make(chan error, 1)
+	go func() {
+		err := encryptStream(random, aesGCMCipher{}, key, KDFParams{}, nil, file, pw)
+		pw.CloseWithError(err)
+		encrypted <- err
+	}()
+
+	err = disperseStream(pr, shares, vandermonde(xs, k))
+	pr.CloseWithError(err)
+	encErr := <-encrypted
+	if err != nil {
+		return err
+	}
+	return encErr
+}
+
+// any k of the keys restore the file, each of them is about 1/k of the file size
+func SplitKrawczyk(random RandomSource, file io.Reader, keys []io.Writer, k int) error {
+	return splitScheme(krawczykScheme{}, random, file, keys, k, 0)
+}
+
+func disperseStream(ciphertext io.Reader, writers []io.Writer, matrix [][]byte) error {
+	k := len(matrix[0])
+	m := chunkSize / k
+	data := make([]byte, k*m)
+	shares := make([][]byte, len(writers))
+	buffers := make([][]byte, len(writers))
+	for i := range buffers {
+		buffers[i] = make([]byte, m)
+	}
+	for {
+		n, err := io.ReadFull(ciphertext, data)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		last := n < len(data)
+		if last {
+			p := k - n%k
+			for j := n; j < n+p; j++ {
+				data[j] = byte(p)
+			}
+			n += p
+		}
+
+		for i := range shares {
+			shares[i] = buffers[i][:n/k]
+		}
+		disperse(matrix, data[:n], shares)
+		for i, w := range writers {
+			_, err := w.Write(shares[i])
+			if err != nil {
+				return err
+			}
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+func SplitKrawczykIntoFiles(random RandomSource, file io.Reader, keys []*os.File, k int) error {
+	keyWriters := make([]io.Writer, len(keys))
+	for i, key := range keys {
+		keyWriters[i] = key
+	}
+	return SplitKrawczyk(random, file, keyWriters, k)
+}
+
+// only the first k shares are used
+func (krawczykScheme) JoinStream(file io.Writer, shares []io.Reader, indices []uint16, k int) error {
+	shares, indices = shares[:k], indices[:k]
+
+	keyShares := make([][]byte, k)
+	for i, r := range shares {
+		keyShares[i] = make([]byte, hybridKeySize)
+		_, err := io.ReadFull(r, keyShares[i])
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return errorf(ErrShareDamaged, "key %d is truncated", i)
+			}
+			return fmt.Errorf("key %d: %w", i, err)
+		}
+	}
+	xs := make([]byte, k)
+	for i, index := range indices {
+		xs[i] = byte(index)
+	}
+	key := make([]byte, hybridKeySize)
+	shamirScheme{}.Combine(keyShares, indices, key)
+
+	inverse, ok := gfInvert(vandermonde(xs, k))
+	if !ok {
+		return errorf(ErrShareMismatch, "keys have repeated share indices")
+	}
+
+	// the ciphertext is decrypted as it is restored
+	pr, pw := io.Pipe()
+	decrypted := make(chan error, 1)
+	go func() {
+		err := decryptStream(aesGCMCipher{}, key, nil, bufio.NewReader(pr), file)
+		pr.CloseWithError(err)
+		decrypted <- err
+	}()
+
+	err := recoverStream(shares, inverse, pw)
+	pw.CloseWithError(err)
+	decErr := <-decrypted
+	if err != nil {
+		return err
+	}
+	return decErr
+}
+
+func recoverStream(readers []io.Reader, inverse [][]byte, ciphertext io.Writer) error {
+	k := len(inverse)
+	m := chunkSize / k
+	out := &unpadWriter{w: ciphertext, k: k}
+	data := make([]byte, k*m)
+	shares := make([][]byte, k)
+	buffers := make([][]byte, k)
+	for i := range buffers {
+		buffers[i] = make([]byte, m)
+	}
+	for {
+		length := 0
+		for i, r := range readers {
+			n, err := io.ReadFull(r, buffers[i])
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return fmt.Errorf("key %d: %w", i, err)
+			}
+			if i == 0 {
+				length = n
+			} else if n != length {
+				return errorf(ErrShareMismatch, "key %d has different length than key 0", i)
+			}
+			shares[i] = buffers[i][:n]
+		}
+
+		if length > 0 {
+			recoverDispersed(inverse, shares, data[:k*length])
+			_, err := out.Write(data[:k*length])
+			if err != nil {
+				return err
+			}
+		}
+		if length < m {
+			return out.Close()
+		}
+	}
+}
+
+// any k of the keys produced by SplitKrawczyk restore the file
+func JoinKrawczyk(file io.Writer, keys []io.Reader) error {
+	if len(keys) < 2 {
+		return errLessThanTwoKeys
+	}
+	return joinStream(file, keys, SchemeKrawczyk, 0)
+}
+
+func JoinKrawczykFromFiles(file io.Writer, keys []*os.File) error {
+	keyReaders := make([]io.Reader, len(keys))
+	for i, key := range keys {
+		keyReaders[i] = key
+	}
+	return JoinKrawczyk(file, keyReaders)
+}
@@ -0,0 +1,68 @@
+package bitsplit
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestSplitKrawczyk(t *testing.T) {
+	tests := []struct{ n, k, size int }{
+		{2, 2, 0}, {3, 2, 1}, {3, 2, 1000}, {5, 3, 999}, {5, 3, 1001},
+		{4, 4, chunkSize}, {5, 3, 2*chunkSize + 1}, {10, 7, 3 * chunkSize},
+	}
+	for _, test := range tests {
+		data := randomBytes(test.size)
+		shares := splitToBuffers(t, test.n, func(keys []io.Writer) error {
+			return SplitKrawczyk(nil, bytes.NewReader(data), keys, test.k)
+		})
+		// each key is about 1/k of the file, along with the key share and the headers
+		if limit := test.size/test.k + 200; len(shares[0]) > limit {
+			t.Fatalf("n=%d k=%d size %d: key is %d bytes long", test.n, test.k, test.size, len(shares[0]))
+		}
+		for i := 0; i+test.k <= test.n; i++ {
+			var out bytes.Buffer
+			err := JoinKrawczyk(&out, shareReaders(shares[i:i+test.k]...))
+			if err != nil {
+				t.Fatalf("n=%d k=%d size %d: %v", test.n, test.k, test.size, err)
+			}
+			if !bytes.Equal(out.Bytes(), data) {
+				t.Fatalf("n=%d k=%d size %d: joined data differs", test.n, test.k, test.size)
+			}
+		}
+		err := JoinKrawczyk(io.Discard, shareReaders(shares[:test.k-1]...))
+		if test.k > 2 && err == nil {
+			t.Fatalf("n=%d k=%d size %d: joined with less than k keys", test.n, test.k, test.size)
+		}
+	}
+}
+
+func TestUnpadWriter(t *testing.T) {
+	tests := []struct {
+		padded []byte
+		want   []byte
+	}{
+		{[]byte{3, 3, 3}, []byte{}},
+		{[]byte{9, 1}, []byte{9}},
+		{[]byte{9, 8, 2, 2}, []byte{9, 8}},
+		{[]byte{9, 8, 7}, nil},
+		{[]byte{9, 3, 2}, nil},
+		{[]byte{9, 9, 0}, nil},
+		{[]byte{}, nil},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		u := &unpadWriter{w: &out, k: 3}
+		u.Write(test.padded)
+		err := u.Close()
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("%v: unpadded to %v", test.padded, out.Bytes())
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(out.Bytes(), test.want) {
+			t.Errorf("%v: unpadded to %v: %v", test.padded, out.Bytes(), err)
+		}
+	}
+}
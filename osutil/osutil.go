@@ -2,15 +2,19 @@ package osutil
 
 import (
 	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"github.com/imobulus/bitsplit"
 	"io/ioutil"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"syscall"
+
+	"golang.org/x/term"
 )
 
 func Prompt(action string) bool {
@@ -25,6 +29,14 @@ func Promptf(format string, a ...string) bool {
 	return Prompt(fmt.Sprintf(format, a))
 }
 
+// reads a password from the terminal without echo
+func ReadPassword(prompt string) ([]byte, error) {
+	fmt.Print(prompt)
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	return password, err
+}
+
 func FileExists(filename string) bool {
 	info, err := os.Stat(filename)
 	if os.IsNotExist(err) {
@@ -149,3 +161,55 @@ func CopyDir(src, dst string) error {
 	})
 	return err
 }
+
+// the context is cancelled on the first interrupt, so the running operation can stop and clean up.
+// Further interrupts are ignored until the returned function is called
+func InterruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		select {
+		case <-interrupt:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(interrupt)
+		cancel()
+	}
+}
+
+// prints the progress on one line of stderr, nil if stderr is not a terminal
+func ProgressBar(label string) bitsplit.Progress {
+	if !term.IsTerminal(int(os.Stderr.Fd())) {
+		return nil
+	}
+	const width = 30
+	last := int64(-1)
+	return func(done, total int64) {
+		if total <= 0 {
+			// only the bytes, every megabyte
+			if done>>20 != last {
+				last = done >> 20
+				fmt.Fprintf(os.Stderr, "\r%s %d MiB", label, last)
+			}
+			return
+		}
+		percent := done * 100 / total
+		if percent > 100 {
+			percent = 100
+		}
+		if percent == last {
+			return
+		}
+		last = percent
+		filled := int(percent * width / 100)
+		fmt.Fprintf(os.Stderr, "\r%s [%s%s] %3d%%",
+			label, strings.Repeat("=", filled), strings.Repeat(" ", width-filled), percent)
+		if done >= total {
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package bitsplit
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Reed-Solomon parity for key files kept on media that rot. The payload is cut into blocks of up to
+// 223 bytes, the bytes of a block are the values at x = 1, 2, ... of a polynomial of degree less than
+// the block size and the 32 parity bytes after the block are its values at the next x.
+// Up to 16 damaged bytes of a block are corrected with the Berlekamp-Welch decoder.
+// The header and the trailer get 32 parity bytes each the same way, the checksum and length
+// in the trailer are of the payload without the parity
+
+const (
+	parityBlockSize = 223
+	paritySize      = 32
+)
+
+var (
+	parityMatricesLock sync.Mutex
+	parityMatrices     = make(map[int][][]byte)
+)
+
+// lagrange coefficients of the parity bytes of a block of size m
+func parityMatrix(m int) [][]byte {
+	parityMatricesLock.Lock()
+	defer parityMatricesLock.Unlock()
+	matrix, ok := parityMatrices[m]
+	if !ok {
+		xs := make([]byte, m)
+		for i := range xs {
+			xs[i] = byte(i + 1)
+		}
+		matrix = make([][]byte, paritySize)
+		for j := range matrix {
+			matrix[j] = lagrangeCoefficients(xs, byte(m+j+1))
+		}
+		parityMatrices[m] = matrix
+	}
+	return matrix
+}
+
+func parityEncode(data, parity []byte) {
+	for j, row := range parityMatrix(len(data)) {
+		y := byte(0)
+		for i, c := range row {
+			y ^= gfMul(c, data[i])
+		}
+		parity[j] = y
+	}
+}
+
+// corrects the block of m bytes followed by its parity in place, returns the number of corrected bytes
+// and false if the block is damaged too much
+func parityDecode(block []byte, m int) (int, bool) {
+	parity := make([]byte, paritySize)
+	parityEncode(block[:m], parity)
+	damaged := false
+	for j, b := range parity {
+		if b != block[m+j] {
+			damaged = true
+			break
+		}
+	}
+	if !damaged {
+		return 0, true
+	}
+
+	xs := make([]byte, len(block))
+	for i := range xs {
+		xs[i] = byte(i + 1)
+	}
+	p, ok := berlekampWelch(xs, block, m)
+	if !ok {
+		return 0, false
+	}
+	corrected := 0
+	for i, x := range xs {
+		y := gfPolyEval(p, x)
+		if y != block[i] {
+			block[i] = y
+			corrected++
+		}
+	}
+	return corrected, true
+}
+
+// splits the file like SplitWith, every key gets Reed-Solomon parity that corrects up to
+// 16 damaged bytes in every 255
+func SplitWithParity(s Scheme, random RandomSource, file io.Reader, keys []io.Writer, k int) error {
+	return splitScheme(s, random, file, keys, k, shareFlagParity)
+}
+
+func SplitWithParityIntoFiles(s Scheme, random RandomSource, file io.Reader, keys []*os.File, k int) error {
+	keyWriters := make([]io.Writer, len(keys))
+	for i, key := range keys {
+		keyWriters[i] = key
+	}
+	return SplitWithParity(s, random, file, keyWriters, k)
+}
+
+// writes the key made with parity to output with the damaged bytes corrected,
+// returns the number of corrected bytes
+func RepairShare(key io.Reader, output io.Writer) (int, error) {
+	r, err := newShareReader(key)
+	if err != nil {
+		return 0, err
+	}
+	if r.legacy {
+		return 0, fmt.Errorf("not a share file")
+	}
+	if r.Flags&shareFlagParity == 0 {
+		return 0, fmt.Errorf("key has no parity, it can't be repaired")
+	}
+
+	w, err := newShareWriter(output, r.shareHeader)
+	if err != nil {
+		return r.corrected, err
+	}
+	_, err = io.Copy(w, r)
+	if err != nil {
+		return r.corrected, err
+	}
+	w.digest = r.digest
+	return r.corrected, w.Close()
+}
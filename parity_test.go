@@ -0,0 +1,115 @@
+package bitsplit
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestParityDecode(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	for _, m := range []int{1, 2, 17, 100} {
+		for e := 0; e <= paritySize/2+1; e++ {
+			data := make([]byte, m)
+			rng.Read(data)
+			block := make([]byte, m+paritySize)
+			copy(block, data)
+			parityEncode(data, block[m:])
+			parity := append([]byte(nil), block[m:]...)
+			corrupt(rng, block, e)
+
+			corrected, ok := parityDecode(block, m)
+			if e > paritySize/2 {
+				if ok {
+					t.Errorf("m=%d: %d errors are decoded", m, e)
+				}
+				continue
+			}
+			if !ok || corrected != e {
+				t.Errorf("m=%d: %d errors, corrected %d %v", m, e, corrected, ok)
+				continue
+			}
+			if !bytes.Equal(block[:m], data) || !bytes.Equal(block[m:], parity) {
+				t.Errorf("m=%d: %d errors are corrected wrong", m, e)
+			}
+		}
+	}
+}
+
+func TestParityRoundTrip(t *testing.T) {
+	// the salt and data of these fill a parity block exactly, or one byte less or more
+	block := parityBlockSize - saltSize
+	sizes := []int{0, 1, block - 1, block, block + 1, chunkSize + 1}
+	rng := rand.New(rand.NewSource(5))
+	for _, scheme := range []Scheme{additiveScheme{}, xorScheme{}, shamirScheme{}, krawczykScheme{}} {
+		k := 3
+		if scheme.CheckParams(3, 2) == nil {
+			k = 2
+		}
+		for _, size := range sizes {
+			data := randomBytes(size)
+			keys := splitToBuffers(t, 3, func(keys []io.Writer) error {
+				return SplitWithParity(scheme, nil, bytes.NewReader(data), keys, k)
+			})
+
+			// 16 damaged bytes in the first 255 of every key, the header and its parity
+			shares := make([][]byte, len(keys))
+			for i, key := range keys {
+				shares[i] = append([]byte(nil), key...)
+				for _, j := range rng.Perm(shareHeaderSize + paritySize)[:paritySize/2] {
+					shares[i][j] ^= 0xff
+				}
+			}
+			var out bytes.Buffer
+			report, err := JoinWithReport(&out, shareReaders(shares[:k]...))
+			if err != nil {
+				t.Fatalf("%s, size %d: %v", scheme.Name(), size, err)
+			}
+			if !bytes.Equal(out.Bytes(), data) {
+				t.Fatalf("%s, size %d: joined data differs", scheme.Name(), size)
+			}
+			for i, n := range report.Corrected {
+				if n != paritySize/2 {
+					t.Fatalf("%s, size %d: key %d has %d corrected bytes, want %d", scheme.Name(), size, i, n, paritySize/2)
+				}
+			}
+
+			var repaired bytes.Buffer
+			n, err := RepairShare(bytes.NewReader(shares[0]), &repaired)
+			if err != nil || n != paritySize/2 || !bytes.Equal(repaired.Bytes(), keys[0]) {
+				t.Fatalf("%s, size %d: repair corrected %d: %v", scheme.Name(), size, n, err)
+			}
+		}
+	}
+}
+
+func TestParityTooDamaged(t *testing.T) {
+	keys := splitToBuffers(t, 2, func(keys []io.Writer) error {
+		return SplitWithParity(xorScheme{}, nil, bytes.NewReader(make([]byte, 1000)), keys, 2)
+	})
+	share := append([]byte(nil), keys[0]...)
+	// the second block of the payload
+	start := shareHeaderSize + paritySize + parityBlockSize + paritySize
+	for j := start; j < start+paritySize/2+1; j++ {
+		share[j] ^= 0xff
+	}
+	err := Join(io.Discard, shareReaders(share, keys[1]))
+	if err == nil {
+		t.Fatal("a block with 17 damaged bytes is joined")
+	}
+	_, err = RepairShare(bytes.NewReader(share), io.Discard)
+	if err == nil {
+		t.Fatal("a block with 17 damaged bytes is repaired")
+	}
+}
+
+func TestParityRequired(t *testing.T) {
+	keys := splitToBuffers(t, 2, func(keys []io.Writer) error {
+		return Split(bytes.NewReader(randomBytes(100)), keys)
+	})
+	_, err := RepairShare(bytes.NewReader(keys[0]), io.Discard)
+	if err == nil {
+		t.Fatal("repaired a key without parity")
+	}
+}
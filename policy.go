@@ -0,0 +1,516 @@
+package bitsplit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Policies describe who may restore a file, like and(2of(admin1,admin2,admin3), 1of(auditor1,auditor2)).
+// and(...) needs all of its parts, or(...) any one of them and Kof(...) any K. A part followed by :W
+// counts W times, so in 2of(cto:2,dev1,dev2) the cto alone is enough.
+// Like in SplitHybrid the file is encrypted with a random key. The key is shared among the parts of
+// the outer clause and the share of every inner clause is shared again among its parts, down to the holders.
+// Clauses needing one part give every part the secret itself, clauses needing all parts use
+// the additive scheme and the others Shamir's scheme.
+// Every holder gets one key file with all of its shares, the header has the holder number as the index,
+// the number of holders as the total and threshold 0:
+//   payload: policy length (uint16), policy, number of shares (uint16), then for every share
+//            the clause number (uint16), part number (uint16), share length (uint32) and the share
+// Clauses are numbered from 0 in the order they appear in the policy
+
+//---- parsing ----
+
+type policyNode struct {
+	// holders have a label, clauses have parts
+	label  string
+	name   string
+	k      int
+	parts  []*policyNode
+	weight int
+	number int
+}
+
+// the number of shares the parts of the clause get
+func (n *policyNode) total() int {
+	total := 0
+	for _, part := range n.parts {
+		total += part.weight
+	}
+	return total
+}
+
+func (n *policyNode) String() string {
+	s := n.label
+	if n.label == "" {
+		parts := make([]string, len(n.parts))
+		for i, part := range n.parts {
+			parts[i] = part.String()
+		}
+		s = n.name + "(" + strings.Join(parts, ",") + ")"
+	}
+	if n.weight > 1 {
+		s += ":" + strconv.Itoa(n.weight)
+	}
+	return s
+}
+
+type Policy struct {
+	root    *policyNode
+	clauses []*policyNode
+	holders []string
+}
+
+func ParsePolicy(s string) (*Policy, error) {
+	parser := &policyParser{s: s}
+	root, err := parser.node()
+	if err != nil {
+		return nil, err
+	}
+	parser.skipSpace()
+	if parser.pos < len(s) {
+		return nil, parser.errorf("unexpected %q", s[parser.pos])
+	}
+	if root.label != "" {
+		return nil, fmt.Errorf("policy must be a clause, not a single holder")
+	}
+	root.weight = 1
+
+	p := &Policy{root: root}
+	seen := make(map[string]bool)
+	var walk func(n *policyNode)
+	walk = func(n *policyNode) {
+		if n.label != "" {
+			if !seen[n.label] {
+				seen[n.label] = true
+				p.holders = append(p.holders, n.label)
+			}
+			return
+		}
+		n.number = len(p.clauses)
+		p.clauses = append(p.clauses, n)
+		for _, part := range n.parts {
+			walk(part)
+		}
+	}
+	walk(root)
+	if len(p.holders) < 2 {
+		return nil, fmt.Errorf("policy must have at least 2 holders")
+	}
+	if len(p.clauses) > 0xffff {
+		return nil, fmt.Errorf("policy has too many clauses")
+	}
+	return p, nil
+}
+
+// holders in the order they first appear in the policy
+func (p *Policy) Holders() []string {
+	return append([]string(nil), p.holders...)
+}
+
+func (p *Policy) String() string {
+	return p.root.String()
+}
+
+type policyParser struct {
+	s   string
+	pos int
+}
+
+func (p *policyParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("policy: "+format+" at position %d", append(args, p.pos)...)
+}
+
+func (p *policyParser) skipSpace() {
+	for p.pos < len(p.s) && strings.IndexByte(" \t\r\n", p.s[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+func (p *policyParser) next(c byte) bool {
+	p.skipSpace()
+	if p.pos < len(p.s) && p.s[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *policyParser) word() string {
+	start := p.pos
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("_-.@", c) >= 0) {
+			break
+		}
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+func (p *policyParser) node() (*policyNode, error) {
+	p.skipSpace()
+	start := p.pos
+	w := p.word()
+	if w == "" {
+		return nil, p.errorf("expected a holder or a clause")
+	}
+	n := &policyNode{weight: 1}
+	if !p.next('(') {
+		n.label = w
+	} else {
+		n.name = w
+		for {
+			part, err := p.node()
+			if err != nil {
+				return nil, err
+			}
+			n.parts = append(n.parts, part)
+			if p.next(')') {
+				break
+			}
+			if !p.next(',') {
+				return nil, p.errorf("expected , or )")
+			}
+		}
+
+		total := n.total()
+		switch {
+		case w == "and":
+			n.k = total
+		case w == "or":
+			n.k = 1
+		case strings.HasSuffix(w, "of"):
+			k, err := strconv.Atoi(w[:len(w)-2])
+			if err != nil || k < 1 {
+				p.pos = start
+				return nil, p.errorf("unknown clause %s", w)
+			}
+			n.k = k
+		default:
+			p.pos = start
+			return nil, p.errorf("unknown clause %s", w)
+		}
+		if n.k > total {
+			p.pos = start
+			return nil, p.errorf("%s has only %d parts", n, total)
+		}
+		if total > 255 {
+			p.pos = start
+			return nil, p.errorf("%s has more than 255 parts", w)
+		}
+	}
+
+	if p.next(':') {
+		p.skipSpace()
+		weight, err := strconv.Atoi(p.word())
+		if err != nil || weight < 1 || weight > 255 {
+			return nil, p.errorf("weight must be between 1 and 255")
+		}
+		n.weight = weight
+	}
+	return n, nil
+}
+
+//---- splitting ----
+
+type policyShare struct {
+	clause, part int
+	value        []byte
+}
+
+// shares the secret among the parts of the clause, the shares of the holders are added to shares.
+// A part of weight w gets w shares one after another
+func (p *Policy) split(random RandomSource, n *policyNode, secret []byte, shares map[string][]policyShare) error {
+	total := n.total()
+	values := make([][]byte, total)
+	for i := range values {
+		values[i] = make([]byte, len(secret))
+	}
+	var err error
+	switch {
+	case n.k == 1:
+		for _, v := range values {
+			copy(v, secret)
+		}
+	case n.k == total:
+		err = additiveScheme{}.Split(random, secret, values, total)
+	default:
+		err = shamirScheme{}.Split(random, secret, values, n.k)
+	}
+	if err != nil {
+		return err
+	}
+
+	x := 0
+	for i, part := range n.parts {
+		value := bytes.Join(values[x:x+part.weight], nil)
+		x += part.weight
+		if part.label != "" {
+			shares[part.label] = append(shares[part.label], policyShare{n.number, i, value})
+			continue
+		}
+		err := p.split(random, part, value, shares)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encrypts the file like SplitHybrid and writes the key file of every holder of the policy
+// to keys in the order of Holders
+func SplitPolicy(random RandomSource, file io.Reader, ciphertext io.Writer, p *Policy, keys []io.Writer) error {
+	if len(keys) != len(p.holders) {
+		return fmt.Errorf("%d keys for %d holders of the policy", len(keys), len(p.holders))
+	}
+	key, err := GenerateKey(random, hybridKeySize)
+	if err != nil {
+		return err
+	}
+	shares := make(map[string][]policyShare)
+	err = p.split(random, p.root, key, shares)
+	if err != nil {
+		return err
+	}
+
+	id, err := newSetID(random)
+	if err != nil {
+		return err
+	}
+	header := shareHeader{
+		Scheme: SchemePolicy,
+		Flags:  shareFlagHybrid,
+		SetID:  id,
+		Total:  uint16(len(p.holders)),
+	}
+	text := p.String()
+	digest := sha256.Sum256(key)
+	for i, holder := range p.holders {
+		payload := make([]byte, 2, 2+len(text)+2)
+		binary.BigEndian.PutUint16(payload, uint16(len(text)))
+		payload = append(payload, text...)
+		payload = append(payload, 0, 0)
+		binary.BigEndian.PutUint16(payload[len(payload)-2:], uint16(len(shares[holder])))
+		for _, s := range shares[holder] {
+			entry := make([]byte, 2+2+4)
+			binary.BigEndian.PutUint16(entry, uint16(s.clause))
+			binary.BigEndian.PutUint16(entry[2:], uint16(s.part))
+			binary.BigEndian.PutUint32(entry[4:], uint32(len(s.value)))
+			payload = append(append(payload, entry...), s.value...)
+		}
+
+		header.Index = uint16(i + 1)
+		w, err := newShareWriter(keys[i], header)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(payload)
+		if err != nil {
+			return err
+		}
+		w.digest = digest[:]
+		err = w.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return AesGCMEncryptWithRandom(random, file, ciphertext, key)
+}
+
+func SplitPolicyIntoFiles(random RandomSource, file io.Reader, ciphertext *os.File, p *Policy, keys []*os.File) error {
+	keyWriters := make([]io.Writer, len(keys))
+	for i, key := range keys {
+		keyWriters[i] = key
+	}
+	return SplitPolicy(random, file, ciphertext, p, keyWriters)
+}
+
+//---- joining ----
+
+func readPolicyKey(key io.Reader) (shareHeader, string, []policyShare, error) {
+	r, err := newShareReader(key)
+	if err != nil {
+		return shareHeader{}, "", nil, err
+	}
+	if r.legacy {
+		return shareHeader{}, "", nil, fmt.Errorf("not a share file")
+	}
+	if r.Scheme != SchemePolicy {
+		return r.shareHeader, "", nil, errorf(ErrShareMismatch, "key is split with %s, not by a policy", r.Scheme)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return r.shareHeader, "", nil, err
+	}
+
+	damaged := fmt.Errorf("key payload is damaged")
+	if len(b) < 2 || len(b) < 2+int(binary.BigEndian.Uint16(b))+2 {
+		return r.shareHeader, "", nil, damaged
+	}
+	text := string(b[2 : 2+binary.BigEndian.Uint16(b)])
+	b = b[2+len(text):]
+	shares := make([]policyShare, binary.BigEndian.Uint16(b))
+	b = b[2:]
+	for i := range shares {
+		if len(b) < 8 || len(b) < 8+int(binary.BigEndian.Uint32(b[4:])) {
+			return r.shareHeader, "", nil, damaged
+		}
+		shares[i].clause = int(binary.BigEndian.Uint16(b))
+		shares[i].part = int(binary.BigEndian.Uint16(b[2:]))
+		shares[i].value = b[8 : 8+binary.BigEndian.Uint32(b[4:])]
+		b = b[8+len(shares[i].value):]
+	}
+	if len(b) != 0 {
+		return r.shareHeader, "", nil, damaged
+	}
+	return r.shareHeader, text, shares, nil
+}
+
+func (n *policyNode) met(have map[string]bool) bool {
+	if n.label != "" {
+		return have[n.label]
+	}
+	got := 0
+	for _, part := range n.parts {
+		if part.met(have) {
+			got += part.weight
+		}
+	}
+	return got >= n.k
+}
+
+// tells why the clause is not met
+func (n *policyNode) explain(have map[string]bool) string {
+	got := 0
+	var missing, reasons []string
+	for _, part := range n.parts {
+		if part.met(have) {
+			got += part.weight
+			continue
+		}
+		missing = append(missing, part.String())
+		if part.label == "" {
+			reasons = append(reasons, part.explain(have))
+		}
+	}
+	reason := fmt.Sprintf("%s needs %d more of %s", n, n.k-got, strings.Join(missing, ", "))
+	return strings.Join(append([]string{reason}, reasons...), "; ")
+}
+
+// restores the secret of the clause, it must be met. shares are the shares of the holders
+// by clause and part number
+func (n *policyNode) combine(shares map[[2]int][]byte, length int) ([]byte, error) {
+	var values [][]byte
+	var indices []uint16
+	x := 0
+	for i, part := range n.parts {
+		value := shares[[2]int{n.number, i}]
+		if part.label == "" {
+			var err error
+			value, err = part.combine(shares, length*part.weight)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if value != nil {
+			if len(value) != length*part.weight {
+				return nil, fmt.Errorf("share of %s in %s has invalid length %d", part, n, len(value))
+			}
+			for w := 0; w < part.weight; w++ {
+				values = append(values, value[w*length:(w+1)*length])
+				indices = append(indices, uint16(x+w+1))
+			}
+		}
+		x += part.weight
+	}
+	if len(values) < n.k {
+		return nil, nil
+	}
+
+	secret := make([]byte, length)
+	switch {
+	case n.k == 1:
+		copy(secret, values[0])
+	case n.k == x:
+		additiveScheme{}.Combine(values, indices, secret)
+	default:
+		shamirScheme{}.Combine(values[:n.k], indices[:n.k], secret)
+	}
+	return secret, nil
+}
+
+// restores the key from the key files made by SplitPolicy and decrypts the ciphertext with it.
+// If the keys don't meet the policy, the error tells which clauses are not met
+func JoinPolicy(file io.Writer, ciphertext io.Reader, keys []io.Reader) error {
+	if len(keys) == 0 {
+		return errorf(ErrTooFewShares, "no key files provided")
+	}
+	headers := make([]shareHeader, len(keys))
+	texts := make([]string, len(keys))
+	holderShares := make([][]policyShare, len(keys))
+	for i, key := range keys {
+		var err error
+		headers[i], texts[i], holderShares[i], err = readPolicyKey(key)
+		if err != nil {
+			return fmt.Errorf("key %d: %w", i, err)
+		}
+	}
+
+	p, err := ParsePolicy(texts[0])
+	if err != nil {
+		return fmt.Errorf("key 0: %w", err)
+	}
+	have := make(map[string]bool)
+	shares := make(map[[2]int][]byte)
+	for i, h := range headers {
+		if h.SetID != headers[0].SetID {
+			return errorf(ErrShareMismatch, "key %d belongs to split set %s, but key 0 belongs to %s", i, h.SetID, headers[0].SetID)
+		}
+		if texts[i] != texts[0] || int(h.Total) != len(p.holders) {
+			return errorf(ErrShareMismatch, "key %d has a different policy than key 0", i)
+		}
+		if h.Index == 0 || int(h.Index) > len(p.holders) {
+			return errorf(ErrShareMismatch, "key %d has invalid holder number %d", i, h.Index)
+		}
+		holder := p.holders[h.Index-1]
+		if have[holder] {
+			return errorf(ErrShareMismatch, "key %d belongs to %s like another key", i, holder)
+		}
+		have[holder] = true
+
+		for _, s := range holderShares[i] {
+			if s.clause >= len(p.clauses) || s.part >= len(p.clauses[s.clause].parts) ||
+				p.clauses[s.clause].parts[s.part].label != holder {
+				return fmt.Errorf("key %d: key payload is damaged", i)
+			}
+			shares[[2]int{s.clause, s.part}] = s.value
+		}
+	}
+
+	if !p.root.met(have) {
+		return errorf(ErrTooFewShares, "the keys don't meet the policy: %s", p.root.explain(have))
+	}
+	key, err := p.root.combine(shares, hybridKeySize)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return fmt.Errorf("key files are damaged, the policy is met but the key can't be restored")
+	}
+	return AesGCMDecrypt(ciphertext, file, key)
+}
+
+func JoinPolicyFromFiles(file io.Writer, ciphertext *os.File, keys []*os.File) error {
+	keyReaders := make([]io.Reader, len(keys))
+	for i, key := range keys {
+		keyReaders[i] = key
+	}
+	return JoinPolicy(file, ciphertext, keyReaders)
+}
@@ -0,0 +1,113 @@
+package bitsplit
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		policy  string
+		want    string
+		holders string
+	}{
+		{"and(a,b)", "and(a,b)", "a,b"},
+		{" or( a , b:2 ) ", "or(a,b:2)", "a,b"},
+		{"and(2of(admin1,admin2,admin3),1of(auditor1,auditor2))",
+			"and(2of(admin1,admin2,admin3),1of(auditor1,auditor2))", "admin1,admin2,admin3,auditor1,auditor2"},
+		{"2of(cto:2,dev1,dev2)", "2of(cto:2,dev1,dev2)", "cto,dev1,dev2"},
+		{"or(and(a,b),and(a,c))", "or(and(a,b),and(a,c))", "a,b,c"},
+	}
+	for _, test := range tests {
+		p, err := ParsePolicy(test.policy)
+		if err != nil {
+			t.Errorf("%s: %v", test.policy, err)
+			continue
+		}
+		if p.String() != test.want || strings.Join(p.Holders(), ",") != test.holders {
+			t.Errorf("%s: parsed as %s with holders %v", test.policy, p, p.Holders())
+		}
+	}
+
+	for _, policy := range []string{
+		"", "a", "and(a)", "and(a,b", "and(a,b))", "3of(a,b)", "0of(a,b)", "and(a,b:0)", "xor(a,b)", "and(a,,b)",
+	} {
+		if _, err := ParsePolicy(policy); err == nil {
+			t.Errorf("%q is parsed", policy)
+		}
+	}
+}
+
+func splitPolicyToBuffers(t *testing.T, data []byte, policy string) ([]byte, map[string][]byte) {
+	p, err := ParsePolicy(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	holders := p.Holders()
+	var ciphertext bytes.Buffer
+	keys := splitToBuffers(t, len(holders), func(keys []io.Writer) error {
+		return SplitPolicy(nil, bytes.NewReader(data), &ciphertext, p, keys)
+	})
+	byHolder := make(map[string][]byte)
+	for i, holder := range holders {
+		byHolder[holder] = keys[i]
+	}
+	return ciphertext.Bytes(), byHolder
+}
+
+func TestSplitPolicy(t *testing.T) {
+	data := randomBytes(1000)
+	tests := []struct {
+		policy  string
+		enough  []string
+		missing []string
+	}{
+		{"and(2of(admin1,admin2,admin3),1of(auditor1,auditor2))",
+			[]string{"admin3,admin1,auditor2", "admin1,admin2,admin3,auditor1,auditor2"},
+			[]string{"admin1,admin2,admin3", "admin1,auditor1,auditor2"}},
+		{"2of(cto:2,dev1,dev2)", []string{"cto", "dev1,dev2"}, []string{"dev2"}},
+		{"or(and(a,b),and(a,c))", []string{"a,c", "b,a"}, []string{"b,c", "a"}},
+	}
+	for _, test := range tests {
+		ciphertext, keys := splitPolicyToBuffers(t, data, test.policy)
+		holderKeys := func(holders string) [][]byte {
+			var k [][]byte
+			for _, h := range strings.Split(holders, ",") {
+				k = append(k, keys[h])
+			}
+			return k
+		}
+		for _, holders := range test.enough {
+			var out bytes.Buffer
+			err := JoinPolicy(&out, bytes.NewReader(ciphertext), shareReaders(holderKeys(holders)...))
+			if err != nil {
+				t.Fatalf("%s with %s: %v", test.policy, holders, err)
+			}
+			if !bytes.Equal(out.Bytes(), data) {
+				t.Fatalf("%s with %s: joined data differs", test.policy, holders)
+			}
+		}
+		for _, holders := range test.missing {
+			err := JoinPolicy(io.Discard, bytes.NewReader(ciphertext), shareReaders(holderKeys(holders)...))
+			if err == nil || !strings.Contains(err.Error(), "don't meet the policy") {
+				t.Fatalf("%s with %s: %v", test.policy, holders, err)
+			}
+		}
+	}
+}
+
+func TestJoinPolicyOtherSplit(t *testing.T) {
+	data := randomBytes(100)
+	ciphertext, keys := splitPolicyToBuffers(t, data, "and(a,b)")
+	_, other := splitPolicyToBuffers(t, data, "and(a,b)")
+	err := JoinPolicy(io.Discard, bytes.NewReader(ciphertext), shareReaders(keys["a"], other["b"]))
+	if err == nil {
+		t.Fatal("joined keys of different splits")
+	}
+	err = JoinPolicy(io.Discard, bytes.NewReader(ciphertext), shareReaders(keys["a"], keys["a"]))
+	if err == nil {
+		t.Fatal("joined the same key twice")
+	}
+}
@@ -0,0 +1,45 @@
+package bitsplit
+
+import (
+	"crypto/rand"
+	"io"
+	"math/big"
+)
+
+// RandomSource provides random bytes for shares, keys and nonces.
+// Every function taking a RandomSource uses crypto/rand if it is nil
+type RandomSource interface {
+	io.Reader
+}
+
+func readRandom(random RandomSource, b []byte) error {
+	if random == nil {
+		random = rand.Reader
+	}
+	_, err := io.ReadFull(random, b)
+	if err != nil {
+		return IOError{"while reading random bytes", err}
+	}
+	return nil
+}
+
+func GenerateKey(random RandomSource, length int) ([]byte, error) {
+	key := make([]byte, length)
+	err := readRandom(random, key)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// uniformly random integer in [0, max)
+func randomInt(random RandomSource, max *big.Int) (*big.Int, error) {
+	if random == nil {
+		random = rand.Reader
+	}
+	n, err := rand.Int(random, max)
+	if err != nil {
+		return nil, IOError{"while reading random bytes", err}
+	}
+	return n, nil
+}
@@ -0,0 +1,64 @@
+package bitsplit
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("no randomness")
+}
+
+func TestRandomSource(t *testing.T) {
+	data := randomBytes(100)
+	splits := map[string]func(random RandomSource, keys []io.Writer) error{
+		"additive": func(random RandomSource, keys []io.Writer) error {
+			return SplitWithRandom(random, bytes.NewReader(data), keys)
+		},
+		"shamir": func(random RandomSource, keys []io.Writer) error {
+			return SplitThreshold(random, bytes.NewReader(data), keys, 2)
+		},
+	}
+	for name, split := range splits {
+		// the same source gives the same keys
+		var keys [2][][]byte
+		for i := range keys {
+			random := rand.New(rand.NewSource(1))
+			keys[i] = splitToBuffers(t, 3, func(w []io.Writer) error {
+				return split(random, w)
+			})
+		}
+		for i := range keys[0] {
+			if !bytes.Equal(keys[0][i], keys[1][i]) {
+				t.Fatalf("%s: key %d differs", name, i)
+			}
+		}
+
+		w := []io.Writer{io.Discard, io.Discard, io.Discard}
+		if split(failingReader{}, w) == nil {
+			t.Fatalf("%s: split without random bytes", name)
+		}
+	}
+}
+
+func TestEncryptWithRandom(t *testing.T) {
+	data := randomBytes(100)
+	var encrypted [2]bytes.Buffer
+	for i := range encrypted {
+		err := AesGCMEncryptWithRandom(rand.New(rand.NewSource(1)), bytes.NewReader(data), &encrypted[i], testKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(encrypted[0].Bytes(), encrypted[1].Bytes()) {
+		t.Fatal("the same source gives different files")
+	}
+	if AesGCMEncryptWithRandom(failingReader{}, bytes.NewReader(data), io.Discard, testKey) == nil {
+		t.Fatal("encrypted without random bytes")
+	}
+}
@@ -0,0 +1,240 @@
+package bitsplit
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Proactive refresh: a fresh sharing of zero is added to the shares, so they still restore the same
+// secret, but can't be combined with the shares from before the refresh. The secret is never restored.
+// Refreshed shares get a new split set id, Join refuses to mix them with the old ones
+
+// shares of these schemes are linear, a sharing of zero can be added to them
+func canRefresh(scheme SchemeID) bool {
+	return scheme == SchemeAdditive || scheme == SchemeXOR || scheme == SchemeShamir
+}
+
+func addZeroShare(scheme SchemeID, share, zero []byte) {
+	if scheme == SchemeAdditive {
+		for j, b := range zero {
+			share[j] += b
+		}
+		return
+	}
+	for j, b := range zero {
+		share[j] ^= b
+	}
+}
+
+// refreshes the keys and writes the new ones to outputs in the same order.
+// Key files without a header are refreshed as additive shares and get one
+func Refresh(random RandomSource, keys []io.Reader, outputs []io.Writer) error {
+	if len(keys) < 2 {
+		return errLessThanTwoKeys
+	}
+	if len(outputs) != len(keys) {
+		return fmt.Errorf("%d outputs for %d keys", len(outputs), len(keys))
+	}
+
+	readers := make([]*shareReader, len(keys))
+	legacy := 0
+	for i, key := range keys {
+		var err error
+		readers[i], err = newShareReader(key)
+		if err != nil {
+			return fmt.Errorf("key %d: %w", i, err)
+		}
+		if readers[i].legacy {
+			legacy++
+		}
+	}
+	if legacy == len(keys) {
+		return refreshLegacy(random, readers, outputs)
+	}
+	headers := make([]shareHeader, len(readers))
+	for i, r := range readers {
+		if r.legacy {
+			return errorf(ErrShareMismatch, "key %d: not a share file", i)
+		}
+		headers[i] = r.shareHeader
+	}
+	err := checkShareSet(headers)
+	if err != nil {
+		return err
+	}
+	first := headers[0]
+	if !canRefresh(first.Scheme) {
+		return fmt.Errorf("keys split with %s can't be refreshed", first.Scheme)
+	}
+	scheme, err := schemeByID(first.Scheme)
+	if err != nil {
+		return err
+	}
+	s := scheme.(ChunkScheme)
+
+	id, err := newSetID(random)
+	if err != nil {
+		return err
+	}
+	writers := make([]*shareWriter, len(outputs))
+	for i, output := range outputs {
+		header := headers[i]
+		header.SetID = id
+		writers[i], err = newShareWriter(output, header)
+		if err != nil {
+			return err
+		}
+	}
+
+	// enrolled shares have indices past the total
+	total := first.Total
+	for _, h := range headers {
+		if h.Index > total {
+			total = h.Index
+		}
+	}
+	zero := make([]byte, chunkSize)
+	zeroShares := make([][]byte, total)
+	for i := range zeroShares {
+		zeroShares[i] = make([]byte, chunkSize)
+	}
+	buffers := make([][]byte, len(readers))
+	for i := range buffers {
+		buffers[i] = make([]byte, chunkSize)
+	}
+	for {
+		length := 0
+		for i, r := range readers {
+			n, err := io.ReadFull(r, buffers[i])
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return fmt.Errorf("key %d: %w", i, err)
+			}
+			if i == 0 {
+				length = n
+			} else if n != length {
+				return errorf(ErrShareMismatch, "key %d has different length than key 0", i)
+			}
+		}
+
+		if length > 0 {
+			shares := make([][]byte, len(zeroShares))
+			for i := range shares {
+				shares[i] = zeroShares[i][:length]
+			}
+			err := s.Split(random, zero[:length], shares, int(first.Threshold))
+			if err != nil {
+				return err
+			}
+			for i, w := range writers {
+				addZeroShare(first.Scheme, buffers[i][:length], shares[headers[i].Index-1])
+				_, err := w.Write(buffers[i][:length])
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if length < chunkSize {
+			break
+		}
+	}
+
+	// the refreshed keys join to the same data
+	digest, err := sharesDigest(readers)
+	if err != nil {
+		return err
+	}
+	for _, w := range writers {
+		w.digest = digest
+		err := w.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// key files without a header are summed up with the shorter ones padded with zeros,
+// so every refreshed key is as long as the longest one
+func refreshLegacy(random RandomSource, readers []*shareReader, outputs []io.Writer) error {
+	id, err := newSetID(random)
+	if err != nil {
+		return err
+	}
+	header := shareHeader{
+		Scheme:    SchemeAdditive,
+		SetID:     id,
+		Total:     uint16(len(readers)),
+		Threshold: uint16(len(readers)),
+	}
+	writers := make([]*shareWriter, len(outputs))
+	for i, output := range outputs {
+		header.Index = uint16(i + 1)
+		writers[i], err = newShareWriter(output, header)
+		if err != nil {
+			return err
+		}
+	}
+
+	zero := make([]byte, chunkSize)
+	zeroShares := make([][]byte, len(readers))
+	buffers := make([][]byte, len(readers))
+	for i := range buffers {
+		buffers[i] = make([]byte, chunkSize)
+		zeroShares[i] = make([]byte, chunkSize)
+	}
+	for {
+		longest := 0
+		for i, r := range readers {
+			n, err := io.ReadFull(r.r, buffers[i])
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return IOError{"while reading key", err}
+			}
+			for j := n; j < chunkSize; j++ {
+				buffers[i][j] = 0
+			}
+			if n > longest {
+				longest = n
+			}
+		}
+		if longest == 0 {
+			break
+		}
+
+		shares := make([][]byte, len(zeroShares))
+		for i := range shares {
+			shares[i] = zeroShares[i][:longest]
+		}
+		err := additiveScheme{}.Split(random, zero[:longest], shares, len(shares))
+		if err != nil {
+			return err
+		}
+		for i, w := range writers {
+			addZeroShare(SchemeAdditive, buffers[i][:longest], shares[i])
+			_, err := w.Write(buffers[i][:longest])
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, w := range writers {
+		err := w.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func RefreshIntoFiles(random RandomSource, keys []*os.File, outputs []*os.File) error {
+	keyReaders := make([]io.Reader, len(keys))
+	for i, key := range keys {
+		keyReaders[i] = key
+	}
+	outputWriters := make([]io.Writer, len(outputs))
+	for i, output := range outputs {
+		outputWriters[i] = output
+	}
+	return Refresh(random, keyReaders, outputWriters)
+}
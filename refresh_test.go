@@ -0,0 +1,74 @@
+package bitsplit
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func refreshToBuffers(t *testing.T, keys [][]byte) [][]byte {
+	return splitToBuffers(t, len(keys), func(outputs []io.Writer) error {
+		return Refresh(nil, shareReaders(keys...), outputs)
+	})
+}
+
+func TestRefresh(t *testing.T) {
+	data := randomBytes(2*chunkSize + 5)
+	splits := map[string]func(keys []io.Writer) error{
+		"additive": func(keys []io.Writer) error { return Split(bytes.NewReader(data), keys) },
+		"xor": func(keys []io.Writer) error {
+			return SplitWith(xorScheme{}, nil, bytes.NewReader(data), keys, len(keys))
+		},
+		"shamir": func(keys []io.Writer) error { return SplitThreshold(nil, bytes.NewReader(data), keys, 3) },
+	}
+	for name, split := range splits {
+		keys := splitToBuffers(t, 4, split)
+		refreshed := refreshToBuffers(t, keys)
+		for i := range keys {
+			if bytes.Equal(keys[i], refreshed[i]) {
+				t.Fatalf("%s: key %d is not changed", name, i)
+			}
+		}
+
+		var out bytes.Buffer
+		err := Join(&out, shareReaders(refreshed...))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(out.Bytes(), data) {
+			t.Fatalf("%s: joined data differs", name)
+		}
+
+		// old and refreshed keys belong to different split sets
+		mixed := [][]byte{keys[0], refreshed[1], refreshed[2], refreshed[3]}
+		if Join(io.Discard, shareReaders(mixed...)) == nil {
+			t.Fatalf("%s: joined old and refreshed keys", name)
+		}
+	}
+}
+
+func TestRefreshLegacy(t *testing.T) {
+	// key files without a header of different lengths
+	keys := [][]byte{{1, 2, 3}, {10, 20}, {100}}
+	refreshed := refreshToBuffers(t, keys)
+	var out bytes.Buffer
+	err := Join(&out, shareReaders(refreshed...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), []byte{111, 22, 3}) {
+		t.Fatalf("joined %v", out.Bytes())
+	}
+}
+
+func TestRefreshRefused(t *testing.T) {
+	keys := splitToBuffers(t, 3, func(keys []io.Writer) error {
+		return SplitKrawczyk(nil, bytes.NewReader(randomBytes(100)), keys, 2)
+	})
+	if Refresh(nil, shareReaders(keys...), make([]io.Writer, 3)) == nil {
+		t.Fatal("refreshed krawczyk keys")
+	}
+	if Refresh(nil, shareReaders(keys...), make([]io.Writer, 2)) == nil {
+		t.Fatal("refreshed to fewer outputs")
+	}
+}
@@ -0,0 +1,79 @@
+package bitsplit
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+)
+
+// Resharing joins the keys and splits the result under a new policy in one pass,
+// the secret only goes through memory chunk by chunk. The new keys get a new split set id,
+// so they can't be mixed with the old ones
+
+// remembers if reading failed, then the joining side is to blame
+type pipeReader struct {
+	*io.PipeReader
+	failed bool
+}
+
+func (r *pipeReader) Read(p []byte) (int, error) {
+	n, err := r.PipeReader.Read(p)
+	if err != nil && err != io.EOF {
+		r.failed = true
+	}
+	return n, err
+}
+
+// joins the keys and splits the secret into outputs with the scheme s, any k of them restore the secret.
+// Keys of a hybrid split stay hybrid, the encrypted file doesn't change
+func Reshare(s Scheme, random RandomSource, keys []io.Reader, outputs []io.Writer, k int) error {
+	if len(keys) < 2 {
+		return errLessThanTwoKeys
+	}
+	err := s.CheckParams(len(outputs), k)
+	if err != nil {
+		return err
+	}
+
+	// the flags are taken from the first key, the new keys keep the parity if the old ones have it.
+	// Its header is read from a copy, corrected with the parity like when joining
+	keys = append([]io.Reader(nil), keys...)
+	first := bufio.NewReaderSize(keys[0], shareHeaderSize+paritySize)
+	keys[0] = first
+	flags := uint8(0)
+	b, _ := first.Peek(shareHeaderSize + paritySize)
+	r, err := newShareReader(bytes.NewReader(b))
+	if err == nil && !r.legacy {
+		flags = r.Flags & (shareFlagHybrid | shareFlagParity)
+	}
+
+	pr, pw := io.Pipe()
+	joined := make(chan error, 1)
+	go func() {
+		err := joinStream(pw, keys, 0, flags)
+		pw.CloseWithError(err)
+		joined <- err
+	}()
+
+	secret := &pipeReader{PipeReader: pr}
+	err = splitScheme(s, random, secret, outputs, k, flags)
+	pr.CloseWithError(err)
+	joinErr := <-joined
+	if joinErr != nil && (err == nil || secret.failed) {
+		return joinErr
+	}
+	return err
+}
+
+func ReshareIntoFiles(s Scheme, random RandomSource, keys []*os.File, outputs []*os.File, k int) error {
+	keyReaders := make([]io.Reader, len(keys))
+	for i, key := range keys {
+		keyReaders[i] = key
+	}
+	outputWriters := make([]io.Writer, len(outputs))
+	for i, output := range outputs {
+		outputWriters[i] = output
+	}
+	return Reshare(s, random, keyReaders, outputWriters, k)
+}
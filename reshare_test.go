@@ -0,0 +1,96 @@
+package bitsplit
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestReshare(t *testing.T) {
+	data := randomBytes(2*chunkSize + 3)
+	keys := splitToBuffers(t, 5, func(keys []io.Writer) error {
+		return SplitThreshold(nil, bytes.NewReader(data), keys, 3)
+	})
+	tests := []struct {
+		s    Scheme
+		n, k int
+	}{
+		{additiveScheme{}, 2, 2}, {xorScheme{}, 3, 3}, {shamirScheme{}, 4, 2}, {krawczykScheme{}, 6, 4},
+	}
+	for _, test := range tests {
+		reshared := splitToBuffers(t, test.n, func(outputs []io.Writer) error {
+			return Reshare(test.s, nil, shareReaders(keys[1:4]...), outputs, test.k)
+		})
+		var out bytes.Buffer
+		err := JoinWith(test.s, &out, shareReaders(reshared[test.n-test.k:]...))
+		if err != nil {
+			t.Fatalf("%s: %v", test.s.Name(), err)
+		}
+		if !bytes.Equal(out.Bytes(), data) {
+			t.Fatalf("%s: joined data differs", test.s.Name())
+		}
+	}
+}
+
+func TestReshareHybrid(t *testing.T) {
+	data := randomBytes(1000)
+	ciphertext, keys := splitHybridToBuffers(t, data, 3, 2)
+	reshared := splitToBuffers(t, 4, func(outputs []io.Writer) error {
+		return Reshare(shamirScheme{}, nil, shareReaders(keys[:2]...), outputs, 3)
+	})
+
+	// the keys stay hybrid and open the same encrypted file
+	if Join(io.Discard, shareReaders(reshared...)) == nil {
+		t.Fatal("joined hybrid keys without the encrypted file")
+	}
+	var out bytes.Buffer
+	err := JoinHybrid(&out, bytes.NewReader(ciphertext), shareReaders(reshared[1:]...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("joined data differs")
+	}
+}
+
+func TestReshareParity(t *testing.T) {
+	data := randomBytes(1000)
+	keys := splitToBuffers(t, 3, func(keys []io.Writer) error {
+		return SplitWithParity(shamirScheme{}, nil, bytes.NewReader(data), keys, 2)
+	})
+	// the flags of the first key are damaged, the parity corrects them
+	keys[0] = append([]byte(nil), keys[0]...)
+	keys[0][6] = 0
+	reshared := splitToBuffers(t, 3, func(outputs []io.Writer) error {
+		return Reshare(shamirScheme{}, nil, shareReaders(keys[:2]...), outputs, 2)
+	})
+	for i, key := range reshared {
+		if key[6]&shareFlagParity == 0 {
+			t.Fatalf("reshared key %d has no parity", i)
+		}
+	}
+	var out bytes.Buffer
+	err := Join(&out, shareReaders(reshared[1:]...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("joined data differs")
+	}
+}
+
+func TestReshareErrors(t *testing.T) {
+	keys := splitToBuffers(t, 3, func(keys []io.Writer) error {
+		return SplitThreshold(nil, bytes.NewReader(randomBytes(100)), keys, 3)
+	})
+	outputs := make([]io.Writer, 3)
+	for i := range outputs {
+		outputs[i] = io.Discard
+	}
+	if Reshare(shamirScheme{}, nil, shareReaders(keys[:2]...), outputs, 2) == nil {
+		t.Fatal("reshared with too few keys")
+	}
+	if Reshare(additiveScheme{}, nil, shareReaders(keys...), outputs, 2) == nil {
+		t.Fatal("reshared additive with a threshold")
+	}
+}
@@ -0,0 +1,284 @@
+package bitsplit
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// When more than k shamir shares are joined, the extra ones are used to find altered shares.
+// Every byte is restored from k of the shares and checked against the others. If they don't agree,
+// the Berlekamp-Welch decoder finds the polynomial that all but at most (m - k) / 2 of the m shares
+// lie on, the shares that are off it are left out for the rest of the file
+
+//---- polynomial helpers ----
+
+// coefficients go from the lowest degree up
+func gfPolyEval(p []byte, x byte) byte {
+	y := byte(0)
+	for i := len(p) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ p[i]
+	}
+	return y
+}
+
+// returns false if den doesn't divide num
+func gfPolyDiv(num, den []byte) ([]byte, bool) {
+	rem := append([]byte(nil), num...)
+	quot := make([]byte, len(num)-len(den)+1)
+	lead := den[len(den)-1]
+	for i := len(quot) - 1; i >= 0; i-- {
+		c := gfDiv(rem[i+len(den)-1], lead)
+		quot[i] = c
+		for j, d := range den {
+			rem[i+j] ^= gfMul(c, d)
+		}
+	}
+	for _, r := range rem {
+		if r != 0 {
+			return nil, false
+		}
+	}
+	return quot, true
+}
+
+// finds the polynomial of degree less than k going through all but at most (len(xs) - k) / 2 of the points
+func berlekampWelch(xs, ys []byte, k int) ([]byte, bool) {
+	m := len(xs)
+	e := (m - k) / 2
+	if e == 0 {
+		return nil, false
+	}
+
+	// Q(x_i) = y_i E(x_i) where E is monic of degree e and Q has degree less than e + k,
+	// the unknowns are the coefficients of Q and the lower coefficients of E
+	a := make([][]byte, m)
+	b := make([]byte, m)
+	for i, x := range xs {
+		a[i] = make([]byte, 2*e+k)
+		power := byte(1)
+		for t := 0; t < e+k; t++ {
+			a[i][t] = power
+			if t < e {
+				a[i][e+k+t] = gfMul(ys[i], power)
+			}
+			power = gfMul(power, x)
+		}
+		b[i] = gfMul(ys[i], gfPow(x, e))
+	}
+	solution, ok := gfSolve(a, b)
+	if !ok {
+		return nil, false
+	}
+
+	q := solution[:e+k]
+	errorLocator := append(append([]byte(nil), solution[e+k:]...), 1)
+	p, ok := gfPolyDiv(q, errorLocator)
+	if !ok {
+		return nil, false
+	}
+	p = p[:k]
+
+	off := 0
+	for i, x := range xs {
+		if gfPolyEval(p, x) != ys[i] {
+			off++
+		}
+	}
+	return p, off <= e
+}
+
+//---- combining ----
+
+type robustCombiner struct {
+	xs  []byte
+	k   int
+	bad []bool
+	// the polynomial is evaluated at this x, 0 for the secret
+	at byte
+	// good shares the secret is restored from and the ones it is checked against
+	basis, checks []int
+	// lagrange coefficients of the basis at the x evaluated at and at the x of every check
+	atX  []byte
+	rows [][]byte
+}
+
+func newRobustCombiner(xs []byte, k int, at byte) *robustCombiner {
+	c := &robustCombiner{xs: xs, k: k, bad: make([]bool, len(xs)), at: at}
+	c.update()
+	return c
+}
+
+func (c *robustCombiner) good() int {
+	n := 0
+	for _, bad := range c.bad {
+		if !bad {
+			n++
+		}
+	}
+	return n
+}
+
+// leaves the share out, returns false if too few shares would be left
+func (c *robustCombiner) leaveOut(i int) bool {
+	if c.bad[i] {
+		return true
+	}
+	if c.good() <= c.k {
+		return false
+	}
+	c.bad[i] = true
+	c.update()
+	return true
+}
+
+func (c *robustCombiner) update() {
+	c.basis, c.checks = c.basis[:0], c.checks[:0]
+	for i, bad := range c.bad {
+		if bad {
+			continue
+		}
+		if len(c.basis) < c.k {
+			c.basis = append(c.basis, i)
+		} else {
+			c.checks = append(c.checks, i)
+		}
+	}
+
+	bx := make([]byte, len(c.basis))
+	for i, b := range c.basis {
+		bx[i] = c.xs[b]
+	}
+	c.atX = lagrangeCoefficients(bx, c.at)
+	c.rows = make([][]byte, len(c.checks))
+	for r, i := range c.checks {
+		c.rows[r] = lagrangeCoefficients(bx, c.xs[i])
+	}
+}
+
+func (c *robustCombiner) interpolate(coefficients []byte, shares [][]byte, j int) byte {
+	y := byte(0)
+	for i, b := range c.basis {
+		y ^= gfMul(coefficients[i], shares[b][j])
+	}
+	return y
+}
+
+func (c *robustCombiner) combine(shares [][]byte, secret []byte) error {
+	for j := range secret {
+		consistent := true
+		for r, i := range c.checks {
+			if c.interpolate(c.rows[r], shares, j) != shares[i][j] {
+				consistent = false
+				break
+			}
+		}
+		if consistent {
+			secret[j] = c.interpolate(c.atX, shares, j)
+			continue
+		}
+
+		var xs, ys []byte
+		var index []int
+		for i, bad := range c.bad {
+			if !bad {
+				xs = append(xs, c.xs[i])
+				ys = append(ys, shares[i][j])
+				index = append(index, i)
+			}
+		}
+		p, ok := berlekampWelch(xs, ys, c.k)
+		if !ok {
+			return errorf(ErrShareDamaged, "shares don't agree and there are too few of them to find the altered ones")
+		}
+		for t, x := range xs {
+			if gfPolyEval(p, x) != ys[t] {
+				c.bad[index[t]] = true
+			}
+		}
+		c.update()
+		secret[j] = gfPolyEval(p, c.at)
+	}
+	return nil
+}
+
+// writes the values of the polynomials at x, the secret if x is 0
+func joinRobust(file io.Writer, readers []*shareReader, headers []shareHeader, at byte) ([]uint16, error) {
+	xs := make([]byte, len(headers))
+	for i, h := range headers {
+		xs[i] = byte(h.Index)
+	}
+	c := newRobustCombiner(xs, int(headers[0].Threshold), at)
+	badShares := func() []uint16 {
+		var bad []uint16
+		for i, b := range c.bad {
+			if b {
+				bad = append(bad, headers[i].Index)
+			}
+		}
+		return bad
+	}
+
+	secret := make([]byte, chunkSize)
+	buffers := make([][]byte, len(readers))
+	shares := make([][]byte, len(readers))
+	for i := range buffers {
+		buffers[i] = make([]byte, chunkSize)
+	}
+	for {
+		length := -1
+		for i, r := range readers {
+			if c.bad[i] {
+				continue
+			}
+			n, err := io.ReadFull(r, buffers[i])
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				// damaged shares are left out while there are enough others
+				if c.leaveOut(i) {
+					continue
+				}
+				return badShares(), fmt.Errorf("key %d: %w", i, err)
+			}
+			if length == -1 {
+				length = n
+			} else if n != length {
+				return badShares(), errorf(ErrShareMismatch, "key %d has different length than the others", i)
+			}
+			shares[i] = buffers[i][:n]
+		}
+
+		if length > 0 {
+			err := c.combine(shares, secret[:length])
+			if err != nil {
+				return badShares(), err
+			}
+			_, err = file.Write(secret[:length])
+			if err != nil {
+				return badShares(), IOError{"while writing secret", err}
+			}
+		}
+		if length < chunkSize {
+			return badShares(), nil
+		}
+	}
+}
+
+// joins keys made by SplitThreshold like JoinThreshold. If there are more keys than the threshold,
+// altered keys are found and left out, their share indices are returned
+func JoinThresholdDetect(file io.Writer, keys []io.Reader) ([]uint16, error) {
+	if len(keys) < 2 {
+		return nil, errLessThanTwoKeys
+	}
+	report, err := joinShares(file, keys, SchemeShamir, 0)
+	// the altered shares are returned instead
+	JoinReport{Corrected: report.Corrected}.warn()
+	return report.Altered, err
+}
+
+func JoinThresholdDetectFromFiles(file io.Writer, keys []*os.File) ([]uint16, error) {
+	keyReaders := make([]io.Reader, len(keys))
+	for i, key := range keys {
+		keyReaders[i] = key
+	}
+	return JoinThresholdDetect(file, keyReaders)
+}
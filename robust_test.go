@@ -0,0 +1,168 @@
+package bitsplit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// points of a random polynomial of degree less than k at x = 1..m
+func randomCodeword(rng *rand.Rand, m, k int) (p, xs, ys []byte) {
+	p = make([]byte, k)
+	rng.Read(p)
+	xs = make([]byte, m)
+	ys = make([]byte, m)
+	for i := range xs {
+		xs[i] = byte(i + 1)
+		ys[i] = gfPolyEval(p, xs[i])
+	}
+	return p, xs, ys
+}
+
+// changes e of the values at random positions
+func corrupt(rng *rand.Rand, ys []byte, e int) {
+	for _, i := range rng.Perm(len(ys))[:e] {
+		ys[i] ^= byte(rng.Intn(255) + 1)
+	}
+}
+
+func TestGFPolyDiv(t *testing.T) {
+	// (x + 2)(x + 3) divided by x + 3
+	p := []byte{gfMul(2, 3), 2 ^ 3, 1}
+	q, ok := gfPolyDiv(p, []byte{3, 1})
+	if !ok || !bytes.Equal(q, []byte{2, 1}) {
+		t.Fatalf("got %v %v, want [2 1]", q, ok)
+	}
+	if _, ok := gfPolyDiv(p, []byte{4, 1}); ok {
+		t.Fatal("x + 4 doesn't divide (x + 2)(x + 3)")
+	}
+}
+
+func TestBerlekampWelch(t *testing.T) {
+	tests := []struct{ m, k int }{
+		{3, 1}, {4, 2}, {5, 2}, {5, 3}, {7, 3}, {10, 4}, {20, 5}, {40, 30},
+	}
+	rng := rand.New(rand.NewSource(1))
+	for _, test := range tests {
+		maxErrors := (test.m - test.k) / 2
+		for e := 0; e <= maxErrors; e++ {
+			p, xs, ys := randomCodeword(rng, test.m, test.k)
+			corrupt(rng, ys, e)
+			got, ok := berlekampWelch(xs, ys, test.k)
+			if !ok || !bytes.Equal(got, p) {
+				t.Errorf("m=%d k=%d: %d errors are not corrected", test.m, test.k, e)
+			}
+		}
+		if maxErrors+1 > test.m-test.k {
+			continue
+		}
+		_, xs, ys := randomCodeword(rng, test.m, test.k)
+		corrupt(rng, ys, maxErrors+1)
+		if _, ok := berlekampWelch(xs, ys, test.k); ok {
+			t.Errorf("m=%d k=%d: %d errors are decoded", test.m, test.k, maxErrors+1)
+		}
+	}
+}
+
+func TestBerlekampWelchNoRedundancy(t *testing.T) {
+	// with less than 2 extra points no error can be corrected
+	_, xs, ys := randomCodeword(rand.New(rand.NewSource(2)), 4, 3)
+	if _, ok := berlekampWelch(xs, ys, 3); ok {
+		t.Fatal("decoded without redundancy")
+	}
+}
+
+// recomputes the tag of a share after it was altered
+func retag(share []byte) []byte {
+	share = append([]byte(nil), share...)
+	n := len(share) - sha256.Size
+	tag := sha256.Sum256(share[:n])
+	copy(share[n:], tag[:])
+	return share
+}
+
+func TestJoinThresholdDetect(t *testing.T) {
+	sizes := []int{1, chunkSize - 1, chunkSize, chunkSize + 1, 2*chunkSize + 7}
+	for _, size := range sizes {
+		data := randomBytes(size)
+		shares := splitToBuffers(t, 5, func(keys []io.Writer) error {
+			return SplitThreshold(nil, bytes.NewReader(data), keys, 2)
+		})
+
+		// the payload starts with the salt right after the header, alter a byte near the end of it
+		altered := append([][]byte(nil), shares...)
+		i := shareHeaderSize + saltSize + size - 1
+		altered[3] = append([]byte(nil), shares[3]...)
+		altered[3][i] ^= 0x5a
+		altered[3] = retag(altered[3])
+
+		var out bytes.Buffer
+		bad, err := JoinThresholdDetect(&out, shareReaders(altered...))
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(out.Bytes(), data) {
+			t.Fatalf("size %d: joined data differs", size)
+		}
+		if len(bad) != 1 || bad[0] != 4 {
+			t.Fatalf("size %d: altered shares %v, want [4]", size, bad)
+		}
+
+		// Join leaves the altered share out as well
+		out.Reset()
+		err = Join(&out, shareReaders(altered...))
+		if err != nil || !bytes.Equal(out.Bytes(), data) {
+			t.Fatalf("size %d: Join: %v", size, err)
+		}
+
+		// 5 shares of threshold 2 find at most one altered share
+		altered[0] = append([]byte(nil), shares[0]...)
+		altered[0][i] ^= 0x33
+		altered[0] = retag(altered[0])
+		_, err = JoinThresholdDetect(io.Discard, shareReaders(altered...))
+		if err == nil {
+			t.Fatalf("size %d: joined with two altered shares", size)
+		}
+	}
+}
+
+func TestJoinThresholdDetectDamaged(t *testing.T) {
+	data := randomBytes(2*chunkSize + 7)
+	shares := splitToBuffers(t, 4, func(keys []io.Writer) error {
+		return SplitThreshold(nil, bytes.NewReader(data), keys, 2)
+	})
+	// a damaged share is left out while there are enough others
+	damaged := append([][]byte(nil), shares...)
+	damaged[1] = append([]byte(nil), shares[1]...)
+	damaged[1][len(damaged[1])-1] ^= 1
+
+	var out bytes.Buffer
+	_, err := JoinThresholdDetect(&out, shareReaders(damaged...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("joined data differs")
+	}
+}
+
+func TestJoinWithReport(t *testing.T) {
+	data := randomBytes(1000)
+	keys := splitToBuffers(t, 5, func(keys []io.Writer) error {
+		return SplitThreshold(nil, bytes.NewReader(data), keys, 2)
+	})
+	keys[3] = alterPayload(keys[3], 10)
+	var out bytes.Buffer
+	report, err := JoinWithReport(&out, shareReaders(keys...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("joined data differs")
+	}
+	if len(report.Altered) != 1 || report.Altered[0] != 4 || len(report.Corrected) != 5 {
+		t.Fatalf("report %+v, want share 4 altered", report)
+	}
+}
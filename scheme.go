@@ -0,0 +1,215 @@
+package bitsplit
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// Scheme is a way of splitting a file into shares, it is either a ChunkScheme or a StreamScheme.
+// The scheme id is stored in the header of every share, so Join picks the scheme by itself.
+// The package writes the header and trailer of the shares, the scheme only their payloads
+type Scheme interface {
+	ID() SchemeID
+	Name() string
+	// checks that n shares of which any k restore the secret are supported
+	CheckParams(n, k int) error
+}
+
+// splits the file chunk by chunk into shares of the same size as the file
+type ChunkScheme interface {
+	Scheme
+	// fills shares[i] with the share with index i+1 of the secret, every share is as long as the secret
+	Split(random RandomSource, secret []byte, shares [][]byte, k int) error
+	// restores the secret from the shares with the given indices
+	Combine(shares [][]byte, indices []uint16, secret []byte)
+}
+
+// splits the whole file at once, the shares may be of any size, like the ones of Krawczyk's scheme
+type StreamScheme interface {
+	Scheme
+	// writes the payload of the share with index i+1 of the file to shares[i]
+	SplitStream(random RandomSource, file io.Reader, shares []io.Writer, k int) error
+	// restores the file from the payloads of at least k shares with the given indices
+	JoinStream(file io.Writer, shares []io.Reader, indices []uint16, k int) error
+}
+
+var schemes = make(map[SchemeID]Scheme)
+
+// makes the scheme available by its id and name, panics if either is already taken,
+// the id is reserved or the scheme is neither a ChunkScheme nor a StreamScheme
+func RegisterScheme(s Scheme) {
+	_, chunk := s.(ChunkScheme)
+	_, stream := s.(StreamScheme)
+	if !chunk && !stream {
+		panic("bitsplit: scheme " + s.Name() + " has neither chunk nor stream methods")
+	}
+	// 0 stands for any scheme when joining, verifiable and policy keys are joined by their own functions
+	switch s.ID() {
+	case 0, SchemeFeldman, SchemePolicy:
+		panic(fmt.Sprintf("bitsplit: scheme %s has reserved id %d", s.Name(), s.ID()))
+	}
+	for _, other := range schemes {
+		if other.ID() == s.ID() || other.Name() == s.Name() {
+			panic("bitsplit: scheme " + s.Name() + " is registered twice")
+		}
+	}
+	schemes[s.ID()] = s
+}
+
+func GetScheme(name string) (Scheme, error) {
+	for _, s := range schemes {
+		if s.Name() == name {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown scheme %s", name)
+}
+
+// sorted names of registered schemes
+func SchemeNames() []string {
+	names := make([]string, 0, len(schemes))
+	for _, s := range schemes {
+		names = append(names, s.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func schemeByID(id SchemeID) (Scheme, error) {
+	s, ok := schemes[id]
+	if !ok {
+		return nil, fmt.Errorf("keys are split with %s", id)
+	}
+	return s, nil
+}
+
+// splits the file into keys with the scheme s, any k of them restore the file
+func SplitWith(s Scheme, random RandomSource, file io.Reader, keys []io.Writer, k int) error {
+	return splitScheme(s, random, file, keys, k, 0)
+}
+
+func SplitWithIntoFiles(s Scheme, random RandomSource, file io.Reader, keys []*os.File, k int) error {
+	keyWriters := make([]io.Writer, len(keys))
+	for i, key := range keys {
+		keyWriters[i] = key
+	}
+	return SplitWith(s, random, file, keyWriters, k)
+}
+
+func splitScheme(s Scheme, random RandomSource, file io.Reader, keys []io.Writer, k int, flags uint8) error {
+	header, err := newSplitHeader(s, random, keys, k, flags)
+	if err != nil {
+		return err
+	}
+	switch s := s.(type) {
+	case ChunkScheme:
+		w, err := newSplitWriter(random, keys, header, s)
+		if err != nil {
+			return err
+		}
+		_, err = w.ReadFrom(file)
+		if err != nil {
+			return err
+		}
+		return w.Close()
+	case StreamScheme:
+		return splitStreamScheme(s, random, file, keys, header)
+	}
+	return fmt.Errorf("scheme %s has neither chunk nor stream methods", s.Name())
+}
+
+func newSchemeSplitWriter(s ChunkScheme, random RandomSource, keys []io.Writer, k int, flags uint8) (*splitWriter, error) {
+	header, err := newSplitHeader(s, random, keys, k, flags)
+	if err != nil {
+		return nil, err
+	}
+	return newSplitWriter(random, keys, header, s)
+}
+
+// checks the parameters and makes the header of a new split
+func newSplitHeader(s Scheme, random RandomSource, keys []io.Writer, k int, flags uint8) (shareHeader, error) {
+	if len(keys) < 2 {
+		return shareHeader{}, errLessThanTwoKeys
+	}
+	if len(keys) > 0xffff {
+		return shareHeader{}, fmt.Errorf("too many keys %d", len(keys))
+	}
+	err := s.CheckParams(len(keys), k)
+	if err != nil {
+		return shareHeader{}, err
+	}
+
+	id, err := newSetID(random)
+	if err != nil {
+		return shareHeader{}, err
+	}
+	return shareHeader{
+		Scheme:    s.ID(),
+		Flags:     flags,
+		SetID:     id,
+		Total:     uint16(len(keys)),
+		Threshold: uint16(k),
+	}, nil
+}
+
+// joins keys made with the scheme s, Join accepts keys of any scheme
+func JoinWith(s Scheme, file io.Writer, keys []io.Reader) error {
+	if len(keys) < 2 {
+		return errLessThanTwoKeys
+	}
+	return joinStream(file, keys, s.ID(), 0)
+}
+
+func JoinWithFromFiles(s Scheme, file io.Writer, keys []*os.File) error {
+	keyReaders := make([]io.Reader, len(keys))
+	for i, key := range keys {
+		keyReaders[i] = key
+	}
+	return JoinWith(s, file, keyReaders)
+}
+
+//---- xor ----
+
+// like the additive scheme, but shares are combined with xor instead of addition mod 256
+type xorScheme struct{}
+
+func (xorScheme) ID() SchemeID { return SchemeXOR }
+func (xorScheme) Name() string { return "xor" }
+func (xorScheme) CheckParams(n, k int) error {
+	if k != n {
+		return fmt.Errorf("xor splitting requires all %d keys, threshold %d is not supported", n, k)
+	}
+	return nil
+}
+
+func (xorScheme) Split(random RandomSource, secret []byte, shares [][]byte, k int) error {
+	copy(shares[0], secret)
+	for _, s := range shares[1:] {
+		err := readRandom(random, s)
+		if err != nil {
+			return err
+		}
+		for j, b := range s {
+			shares[0][j] ^= b
+		}
+	}
+	return nil
+}
+
+func (xorScheme) Combine(shares [][]byte, indices []uint16, secret []byte) {
+	copy(secret, shares[0])
+	for _, s := range shares[1:] {
+		for j, b := range s {
+			secret[j] ^= b
+		}
+	}
+}
+
+func init() {
+	RegisterScheme(additiveScheme{})
+	RegisterScheme(xorScheme{})
+	RegisterScheme(shamirScheme{})
+	RegisterScheme(krawczykScheme{})
+}
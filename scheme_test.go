@@ -0,0 +1,113 @@
+package bitsplit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSchemeNames(t *testing.T) {
+	names := strings.Join(SchemeNames(), ",")
+	if names != "additive,krawczyk,shamir,xor" {
+		t.Fatalf("registered schemes %s", names)
+	}
+	for _, name := range SchemeNames() {
+		s, err := GetScheme(name)
+		if err != nil || s.Name() != name {
+			t.Fatalf("scheme %s: %v", name, err)
+		}
+	}
+	_, err := GetScheme("unknown")
+	if err == nil {
+		t.Fatal("got an unknown scheme")
+	}
+}
+
+// a scheme with neither chunk nor stream methods
+type emptyScheme struct{}
+
+func (emptyScheme) ID() SchemeID               { return 200 }
+func (emptyScheme) Name() string               { return "empty" }
+func (emptyScheme) CheckParams(n, k int) error { return nil }
+
+// a chunk scheme with the given id
+type idScheme struct {
+	additiveScheme
+	id SchemeID
+}
+
+func (s idScheme) ID() SchemeID { return s.id }
+func (s idScheme) Name() string { return fmt.Sprintf("id%d", s.id) }
+
+func TestRegisterScheme(t *testing.T) {
+	refused := []Scheme{shamirScheme{}, emptyScheme{}, idScheme{id: 0}, idScheme{id: SchemeFeldman}, idScheme{id: SchemePolicy}}
+	for _, s := range refused {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("scheme %s registered", s.Name())
+				}
+			}()
+			RegisterScheme(s)
+		}()
+	}
+	if _, err := GetScheme("empty"); err == nil {
+		t.Fatal("scheme with no methods is registered")
+	}
+}
+
+func TestSplitWith(t *testing.T) {
+	sizes := []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1}
+	for _, name := range SchemeNames() {
+		s, _ := GetScheme(name)
+		n, k := 4, 4
+		if s.CheckParams(n, 3) == nil {
+			k = 3
+		}
+		for _, size := range sizes {
+			data := randomBytes(size)
+			shares := splitToBuffers(t, n, func(keys []io.Writer) error {
+				return SplitWith(s, nil, bytes.NewReader(data), keys, k)
+			})
+			for _, join := range []func(io.Writer, []io.Reader) error{
+				Join,
+				func(file io.Writer, keys []io.Reader) error { return JoinWith(s, file, keys) },
+			} {
+				var out bytes.Buffer
+				err := join(&out, shareReaders(shares[n-k:]...))
+				if err != nil {
+					t.Fatalf("%s size %d: %v", name, size, err)
+				}
+				if !bytes.Equal(out.Bytes(), data) {
+					t.Fatalf("%s size %d: joined data differs", name, size)
+				}
+			}
+		}
+	}
+}
+
+func TestJoinWithWrongScheme(t *testing.T) {
+	data := randomBytes(1000)
+	shares := splitToBuffers(t, 3, func(keys []io.Writer) error {
+		return SplitWith(xorScheme{}, nil, bytes.NewReader(data), keys, 3)
+	})
+	err := JoinWith(additiveScheme{}, io.Discard, shareReaders(shares...))
+	if err == nil || !strings.Contains(err.Error(), "xor") {
+		t.Fatalf("joined xor keys as additive: %v", err)
+	}
+}
+
+func TestSplitOneKey(t *testing.T) {
+	for _, name := range SchemeNames() {
+		s, _ := GetScheme(name)
+		for _, k := range []int{1, 2} {
+			err := SplitWith(s, nil, bytes.NewReader(randomBytes(10)), []io.Writer{io.Discard}, k)
+			if !errors.Is(err, ErrTooFewShares) {
+				t.Errorf("%s k=%d: got %v, want ErrTooFewShares", name, k, err)
+			}
+		}
+	}
+}
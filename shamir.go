@@ -0,0 +1,120 @@
+package bitsplit
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Shamir's secret sharing over GF(256), every byte of the file is shared independently.
+// The share index is the x coordinate, the payload holds the polynomial values for every byte
+
+//---- polynomial helpers ----
+
+// evaluates at x the polynomials with constant terms secret and higher terms coefficients
+func shamirEvaluate(dst, secret []byte, coefficients [][]byte, x byte) {
+	for j := range dst {
+		y := byte(0)
+		for c := len(coefficients) - 1; c >= 0; c-- {
+			y = gfMul(y, x) ^ coefficients[c][j]
+		}
+		dst[j] = gfMul(y, x) ^ secret[j]
+	}
+}
+
+// lagrange coefficients for evaluating at x the polynomial going through points with x coordinates xs
+func lagrangeCoefficients(xs []byte, x byte) []byte {
+	coefficients := make([]byte, len(xs))
+	for i, xi := range xs {
+		num, den := byte(1), byte(1)
+		for j, xj := range xs {
+			if i == j {
+				continue
+			}
+			num = gfMul(num, x^xj)
+			den = gfMul(den, xi^xj)
+		}
+		coefficients[i] = gfDiv(num, den)
+	}
+	return coefficients
+}
+
+//---- splitting and joining ----
+type shamirScheme struct{}
+
+func (shamirScheme) ID() SchemeID { return SchemeShamir }
+func (shamirScheme) Name() string { return "shamir" }
+func (shamirScheme) CheckParams(n, k int) error {
+	return checkThreshold(n, k)
+}
+
+func checkThreshold(n, k int) error {
+	if n > 255 {
+		return fmt.Errorf("threshold splitting supports at most 255 keys, got %d", n)
+	}
+	if k < 2 || k > n {
+		return fmt.Errorf("threshold must be between 2 and the number of keys %d, got %d", n, k)
+	}
+	return nil
+}
+
+func (shamirScheme) Split(random RandomSource, secret []byte, shares [][]byte, k int) error {
+	coefficients := make([][]byte, k-1)
+	for i := range coefficients {
+		coefficients[i] = make([]byte, len(secret))
+		err := readRandom(random, coefficients[i])
+		if err != nil {
+			return err
+		}
+	}
+	for i, s := range shares {
+		shamirEvaluate(s, secret, coefficients, byte(i+1))
+	}
+	return nil
+}
+
+func (shamirScheme) Combine(shares [][]byte, indices []uint16, secret []byte) {
+	xs := make([]byte, len(indices))
+	for i, index := range indices {
+		xs[i] = byte(index)
+	}
+	coefficients := lagrangeCoefficients(xs, 0)
+
+	for j := range secret {
+		secret[j] = 0
+	}
+	for i, s := range shares {
+		c := coefficients[i]
+		for j, y := range s {
+			secret[j] ^= gfMul(c, y)
+		}
+	}
+}
+
+func SplitThreshold(random RandomSource, file io.Reader, keys []io.Writer, k int) error {
+	return splitScheme(shamirScheme{}, random, file, keys, k, 0)
+}
+
+func SplitThresholdIntoFiles(random RandomSource, file io.Reader, keys []*os.File, k int) error {
+	keyWriters := make([]io.Writer, len(keys))
+	for i, key := range keys {
+		keyWriters[i] = key
+	}
+	return SplitThreshold(random, file, keyWriters, k)
+}
+
+// any k of the keys produced by SplitThreshold restore the file
+func JoinThreshold(file io.Writer, keys []io.Reader) error {
+	if len(keys) < 2 {
+		return errLessThanTwoKeys
+	}
+	return joinStream(file, keys, SchemeShamir, 0)
+}
+
+func JoinThresholdFromFiles(file io.Writer, keys []*os.File) error {
+	keyReaders := make([]io.Reader, len(keys))
+	for i, key := range keys {
+		keyReaders[i] = key
+	}
+	return JoinThreshold(file, keyReaders)
+}
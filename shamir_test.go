@@ -0,0 +1,118 @@
+package bitsplit
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func shareReaders(shares ...[]byte) []io.Reader {
+	readers := make([]io.Reader, len(shares))
+	for i, s := range shares {
+		readers[i] = bytes.NewReader(s)
+	}
+	return readers
+}
+
+// the contents of the keys written by split
+func splitToBuffers(t *testing.T, n int, split func(keys []io.Writer) error) [][]byte {
+	t.Helper()
+	buffers := make([]*bytes.Buffer, n)
+	writers := make([]io.Writer, n)
+	for i := range buffers {
+		buffers[i] = new(bytes.Buffer)
+		writers[i] = buffers[i]
+	}
+	err := split(writers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shares := make([][]byte, n)
+	for i, b := range buffers {
+		shares[i] = b.Bytes()
+	}
+	return shares
+}
+
+func randomBytes(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	return data
+}
+
+func TestLagrangeCoefficients(t *testing.T) {
+	// the line 5 + 3x goes through (1, 6) and (2, 3)
+	xs := []byte{1, 2}
+	c := lagrangeCoefficients(xs, 0)
+	if y := gfMul(c[0], 6) ^ gfMul(c[1], 3); y != 5 {
+		t.Fatalf("got %d at 0", y)
+	}
+	c = lagrangeCoefficients(xs, 2)
+	if c[0] != 0 || c[1] != 1 {
+		t.Fatalf("coefficients at a known point are %v", c)
+	}
+}
+
+func TestSplitThreshold(t *testing.T) {
+	tests := []struct{ n, k int }{{2, 2}, {3, 2}, {5, 3}, {5, 5}, {255, 2}}
+	for _, test := range tests {
+		data := randomBytes(100)
+		shares := splitToBuffers(t, test.n, func(keys []io.Writer) error {
+			return SplitThreshold(nil, bytes.NewReader(data), keys, test.k)
+		})
+		// every k consecutive keys and all of them
+		subsets := [][][]byte{shares}
+		for i := 0; i+test.k <= test.n; i += test.k {
+			subsets = append(subsets, shares[i:i+test.k])
+		}
+		for _, subset := range subsets {
+			var out bytes.Buffer
+			err := JoinThreshold(&out, shareReaders(subset...))
+			if err != nil {
+				t.Fatalf("n=%d k=%d: %v", test.n, test.k, err)
+			}
+			if !bytes.Equal(out.Bytes(), data) {
+				t.Fatalf("n=%d k=%d: joined data differs", test.n, test.k)
+			}
+		}
+		if test.k > 2 {
+			var out bytes.Buffer
+			err := JoinThreshold(&out, shareReaders(shares[:test.k-1]...))
+			if err == nil && bytes.Equal(out.Bytes(), data) {
+				t.Fatalf("n=%d k=%d: less than k keys restore the file", test.n, test.k)
+			}
+		}
+	}
+}
+
+func TestSplitThresholdParams(t *testing.T) {
+	tests := []struct{ n, k int }{{3, 1}, {3, 4}, {256, 2}}
+	for _, test := range tests {
+		keys := make([]io.Writer, test.n)
+		for i := range keys {
+			keys[i] = io.Discard
+		}
+		err := SplitThreshold(nil, bytes.NewReader([]byte{1}), keys, test.k)
+		if err == nil {
+			t.Errorf("n=%d k=%d: split", test.n, test.k)
+		}
+	}
+}
+
+func TestSplitThresholdChunks(t *testing.T) {
+	for _, size := range []int{chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 5} {
+		data := randomBytes(size)
+		shares := splitToBuffers(t, 4, func(keys []io.Writer) error {
+			return SplitThreshold(nil, bytes.NewReader(data), keys, 3)
+		})
+		var out bytes.Buffer
+		err := JoinThreshold(&out, shareReaders(shares[1:]...))
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(out.Bytes(), data) {
+			t.Fatalf("size %d: joined data differs", size)
+		}
+	}
+}
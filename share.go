@@ -0,0 +1,491 @@
+package bitsplit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// Every key file is a share with the following layout, all numbers are big endian
+//   header:  magic "BSPL", format version, scheme, flags, split set id (16 bytes), share index,
+//            total number of shares, threshold (uint16 each)
+//   payload: the share itself
+//   trailer: payload length (uint64), sha256 of the joined data, sha256 of everything before it
+// Shares with the parity flag have Reed-Solomon parity after the header, every block of the payload
+// and the trailer, see parity.go
+// The length and digests are at the end, so the share can be written in one pass
+// and read chunk by chunk. The tag at the end finds damaged shares, the digest of the joined data
+// finds altered ones after joining. It is all zeros if unknown, like for shares refreshed from old ones.
+// Shares with the salted flag hold a random salt split along with the data, it comes before the data
+// in the payload and the digest is sha256(salt || data). A single share can't be used to check
+// guesses of the data then, the salt is restored only from enough shares.
+// Shares of format version 1 have a crc32 of everything before it instead of both digests
+
+const (
+	ShareFormatVersion = 2
+
+	shareHeaderSize    = 4 + 1 + 1 + 1 + 16 + 2 + 2 + 2
+	shareTrailerSize   = 8 + sha256.Size + sha256.Size
+	shareTrailerSizeV1 = 8 + 4
+)
+
+var shareMagic = []byte("BSPL")
+
+// share flags
+const (
+	// the shares hold the key of a file encrypted by SplitHybrid, SplitVerifiable or SplitPolicy
+	shareFlagHybrid = 1 << iota
+	// the header, payload and trailer are followed by Reed-Solomon parity, see parity.go
+	shareFlagParity
+	// the joined data starts with a salt of the digest
+	shareFlagSalted
+)
+
+const saltSize = 32
+
+type SchemeID uint8
+
+const (
+	SchemeAdditive SchemeID = 1
+	SchemeShamir   SchemeID = 2
+	SchemeKrawczyk SchemeID = 3
+	SchemeXOR      SchemeID = 4
+	SchemeFeldman  SchemeID = 5
+	SchemePolicy   SchemeID = 6
+)
+
+func (id SchemeID) String() string {
+	switch id {
+	case SchemeAdditive:
+		return "additive"
+	case SchemeShamir:
+		return "shamir"
+	case SchemeKrawczyk:
+		return "krawczyk"
+	case SchemeXOR:
+		return "xor"
+	case SchemeFeldman:
+		return "feldman"
+	case SchemePolicy:
+		return "policy"
+	}
+	return fmt.Sprintf("unknown scheme %d", uint8(id))
+}
+
+type setID [16]byte
+
+// random version 4 uuid
+func newSetID(random RandomSource) (setID, error) {
+	var id setID
+	err := readRandom(random, id[:])
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return id, err
+}
+
+func (id setID) String() string {
+	s := hex.EncodeToString(id[:])
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+type shareHeader struct {
+	Version   uint8
+	Scheme    SchemeID
+	Flags     uint8
+	SetID     setID
+	Index     uint16
+	Total     uint16
+	Threshold uint16
+}
+
+func (h shareHeader) marshal() []byte {
+	b := make([]byte, shareHeaderSize)
+	copy(b, shareMagic)
+	b[4] = h.Version
+	b[5] = byte(h.Scheme)
+	b[6] = h.Flags
+	copy(b[7:23], h.SetID[:])
+	binary.BigEndian.PutUint16(b[23:], h.Index)
+	binary.BigEndian.PutUint16(b[25:], h.Total)
+	binary.BigEndian.PutUint16(b[27:], h.Threshold)
+	return b
+}
+
+func parseShareHeader(b []byte) (shareHeader, error) {
+	var h shareHeader
+	h.Version = b[4]
+	if h.Version != 1 && h.Version != ShareFormatVersion {
+		return h, fmt.Errorf("unsupported share format version %d", h.Version)
+	}
+	h.Scheme = SchemeID(b[5])
+	h.Flags = b[6]
+	copy(h.SetID[:], b[7:23])
+	h.Index = binary.BigEndian.Uint16(b[23:])
+	h.Total = binary.BigEndian.Uint16(b[25:])
+	h.Threshold = binary.BigEndian.Uint16(b[27:])
+	return h, nil
+}
+
+//---- writing shares ----
+
+// writes the header right away, the payload is passed through Write and the trailer is written by Close.
+// With the parity flag the payload is written in blocks as they fill up
+type shareWriter struct {
+	w        io.Writer
+	checksum hash.Hash
+	length   uint64
+	// of the joined data, must be set before Close if known
+	digest []byte
+	parity bool
+	block  []byte
+}
+
+func newShareWriter(w io.Writer, header shareHeader) (*shareWriter, error) {
+	header.Version = ShareFormatVersion
+	s := &shareWriter{w: w, checksum: sha256.New(), parity: header.Flags&shareFlagParity != 0}
+	h := header.marshal()
+	s.checksum.Write(h)
+	err := s.writeBlock(h)
+	if err != nil {
+		return nil, err
+	}
+	if s.parity {
+		s.block = make([]byte, 0, parityBlockSize+paritySize)
+	}
+	return s, nil
+}
+
+// writes b followed by its parity if the share has it
+func (s *shareWriter) writeBlock(b []byte) error {
+	if s.parity {
+		parity := make([]byte, paritySize)
+		parityEncode(b, parity)
+		b = append(b, parity...)
+	}
+	_, err := s.w.Write(b)
+	if err != nil {
+		return IOError{"while writing share", err}
+	}
+	return nil
+}
+
+func (s *shareWriter) Write(p []byte) (int, error) {
+	if !s.parity {
+		n, err := s.w.Write(p)
+		s.checksum.Write(p[:n])
+		s.length += uint64(n)
+		if err != nil {
+			return n, IOError{"while writing share", err}
+		}
+		return n, nil
+	}
+
+	s.checksum.Write(p)
+	s.length += uint64(len(p))
+	for rest := p; len(rest) > 0; {
+		n := copy(s.block[len(s.block):parityBlockSize], rest)
+		s.block, rest = s.block[:len(s.block)+n], rest[n:]
+		if len(s.block) == parityBlockSize {
+			err := s.writeBlock(s.block)
+			if err != nil {
+				return 0, err
+			}
+			s.block = s.block[:0]
+		}
+	}
+	return len(p), nil
+}
+
+// writes the trailer, the underlying writer is not closed
+func (s *shareWriter) Close() error {
+	if len(s.block) > 0 {
+		err := s.writeBlock(s.block)
+		if err != nil {
+			return err
+		}
+	}
+	trailer := make([]byte, 8+sha256.Size, shareTrailerSize+paritySize)
+	binary.BigEndian.PutUint64(trailer, s.length)
+	copy(trailer[8:], s.digest)
+	s.checksum.Write(trailer)
+	return s.writeBlock(s.checksum.Sum(trailer))
+}
+
+//---- reading shares ----
+
+// reads the payload of a share, the trailer is held back and checked when the payload ends.
+// With the parity flag damaged bytes are corrected block by block
+type shareReader struct {
+	shareHeader
+	r           *bufio.Reader
+	checksum    hash.Hash
+	trailerSize int
+	length      uint64
+	done        bool
+	// of the joined data, set once the trailer is checked unless it is unknown
+	digest []byte
+	// the key file has no header, it is read from r as is
+	legacy bool
+	// the number of bytes corrected so far
+	corrected int
+	// the corrected payload not read yet
+	block []byte
+}
+
+func newShareReader(r io.Reader) (*shareReader, error) {
+	br := bufio.NewReaderSize(r, chunkSize+shareTrailerSize+paritySize)
+
+	// the header of a share with parity is corrected before anything else,
+	// even the magic or the flags may be damaged
+	h, _ := br.Peek(shareHeaderSize + paritySize)
+	if len(h) == shareHeaderSize+paritySize {
+		block := append([]byte(nil), h...)
+		corrected, ok := parityDecode(block, shareHeaderSize)
+		if ok && bytes.HasPrefix(block, shareMagic) && block[6]&shareFlagParity != 0 {
+			s, err := newShareReaderWithHeader(br, block[:shareHeaderSize])
+			if err != nil {
+				return nil, err
+			}
+			s.corrected = corrected
+			s.trailerSize += paritySize
+			br.Discard(paritySize)
+			return s, nil
+		}
+	}
+
+	h, err := br.Peek(shareHeaderSize)
+	if !bytes.HasPrefix(h, shareMagic) {
+		if err != nil && err != io.EOF {
+			return nil, IOError{"while reading key", err}
+		}
+		return &shareReader{r: br, done: true, legacy: true}, nil
+	}
+	if err != nil {
+		if err == io.EOF {
+			return nil, errorf(ErrShareDamaged, "share is truncated")
+		}
+		return nil, IOError{"while reading key", err}
+	}
+	if h[6]&shareFlagParity != 0 {
+		return nil, errorf(ErrShareDamaged, "share header is damaged beyond repair")
+	}
+	return newShareReaderWithHeader(br, h)
+}
+
+func newShareReaderWithHeader(br *bufio.Reader, h []byte) (*shareReader, error) {
+	s := &shareReader{r: br, checksum: sha256.New(), trailerSize: shareTrailerSize}
+	var err error
+	s.shareHeader, err = parseShareHeader(h)
+	if err != nil {
+		return nil, err
+	}
+	if s.Version == 1 {
+		s.checksum, s.trailerSize = crc32.NewIEEE(), shareTrailerSizeV1
+	}
+	s.checksum.Write(h)
+	br.Discard(shareHeaderSize)
+	return s, nil
+}
+
+// returns io.EOF after the payload, once the trailer is checked
+func (s *shareReader) Read(p []byte) (int, error) {
+	if len(s.block) > 0 {
+		n := copy(p, s.block)
+		s.block = s.block[n:]
+		return n, nil
+	}
+	if s.done {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if len(p) > chunkSize {
+		p = p[:chunkSize]
+	}
+	if s.Flags&shareFlagParity != 0 {
+		return s.readBlock(p)
+	}
+
+	ahead, err := s.r.Peek(len(p) + s.trailerSize)
+	if err != nil && err != io.EOF {
+		return 0, IOError{"while reading key", err}
+	}
+	if len(ahead) < s.trailerSize {
+		s.done = true
+		return 0, errorf(ErrShareDamaged, "share is truncated")
+	}
+
+	n := copy(p, ahead[:len(ahead)-s.trailerSize])
+	if n > 0 {
+		s.checksum.Write(p[:n])
+		s.length += uint64(n)
+		s.r.Discard(n)
+		return n, nil
+	}
+
+	// only the trailer is left
+	s.done = true
+	return 0, s.checkTrailer(ahead)
+}
+
+// corrects the next block of a share with parity
+func (s *shareReader) readBlock(p []byte) (int, error) {
+	ahead, err := s.r.Peek(parityBlockSize + paritySize + s.trailerSize)
+	if err != nil && err != io.EOF {
+		return 0, IOError{"while reading key", err}
+	}
+	if len(ahead) <= s.trailerSize {
+		s.done = true
+		if len(ahead) < s.trailerSize {
+			return 0, errorf(ErrShareDamaged, "share is truncated")
+		}
+		trailer := append([]byte(nil), ahead...)
+		corrected, ok := parityDecode(trailer, len(trailer)-paritySize)
+		if !ok {
+			return 0, errorf(ErrShareDamaged, "share is truncated or damaged beyond repair")
+		}
+		s.corrected += corrected
+		return 0, s.checkTrailer(trailer[:len(trailer)-paritySize])
+	}
+
+	size := len(ahead) - s.trailerSize
+	if size > parityBlockSize+paritySize {
+		size = parityBlockSize + paritySize
+	}
+	if size <= paritySize {
+		s.done = true
+		return 0, errorf(ErrShareDamaged, "share is truncated or damaged: payload length mismatch")
+	}
+	block := append([]byte(nil), ahead[:size]...)
+	corrected, ok := parityDecode(block, size-paritySize)
+	if !ok {
+		s.done = true
+		return 0, errorf(ErrShareDamaged, "share is damaged beyond repair at byte %d of the payload", s.length)
+	}
+	s.corrected += corrected
+	s.r.Discard(size)
+
+	block = block[:size-paritySize]
+	s.checksum.Write(block)
+	s.length += uint64(len(block))
+	n := copy(p, block)
+	s.block = block[n:]
+	return n, nil
+}
+
+func (s *shareReader) checkTrailer(trailer []byte) error {
+	if binary.BigEndian.Uint64(trailer) != s.length {
+		return errorf(ErrShareDamaged, "share is truncated or damaged: payload length mismatch")
+	}
+	tag := len(trailer) - s.checksum.Size()
+	s.checksum.Write(trailer[:tag])
+	if !bytes.Equal(s.checksum.Sum(nil), trailer[tag:]) {
+		return errorf(ErrShareDamaged, "share is damaged: checksum mismatch")
+	}
+	if s.Version != 1 && !bytes.Equal(trailer[8:tag], make([]byte, sha256.Size)) {
+		s.digest = append([]byte(nil), trailer[8:tag]...)
+	}
+	return io.EOF
+}
+
+// the digest of the joined data the keys read to the end agree on, nil if it is unknown
+func sharesDigest(readers []*shareReader) ([]byte, error) {
+	var digest []byte
+	for i, r := range readers {
+		if r.digest == nil {
+			continue
+		}
+		if digest != nil && !bytes.Equal(r.digest, digest) {
+			return nil, errorf(ErrShareMismatch, "key %d has a different digest of the joined data than the others", i)
+		}
+		digest = r.digest
+	}
+	return digest, nil
+}
+
+// passes the joined data of salted shares to w without the salt in front of it,
+// the salt goes only to the digest
+type unsaltWriter struct {
+	w      io.Writer
+	digest io.Writer
+	// bytes of the salt still to come
+	salt int
+}
+
+func (u *unsaltWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if u.salt > 0 {
+		s := u.salt
+		if s > len(p) {
+			s = len(p)
+		}
+		u.digest.Write(p[:s])
+		u.salt -= s
+		p = p[s:]
+	}
+	if len(p) > 0 {
+		_, err := u.w.Write(p)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// checks the joined data against the digest in every key read to the end
+func checkDigest(sum []byte, readers []*shareReader) error {
+	for _, r := range readers {
+		if r.digest != nil && !bytes.Equal(r.digest, sum) {
+			return ErrReconstructionMismatch
+		}
+	}
+	return nil
+}
+
+// checks that the shares come from one split and there are enough of them to restore the secret
+func checkShareSet(headers []shareHeader) error {
+	first := headers[0]
+	for i, h := range headers {
+		if h.SetID != first.SetID {
+			return errorf(ErrShareMismatch, "key %d belongs to split set %s, but key 0 belongs to %s", i, h.SetID, first.SetID)
+		}
+		if h.Scheme != first.Scheme || h.Flags != first.Flags || h.Total != first.Total || h.Threshold != first.Threshold {
+			return errorf(ErrShareMismatch, "key %d has different split parameters than key 0", i)
+		}
+		// shamir shares enrolled after the split have indices past the total
+		if h.Index == 0 || h.Index > h.Total && (h.Scheme != SchemeShamir || h.Index > 255) {
+			return errorf(ErrShareMismatch, "key %d has invalid share index %d", i, h.Index)
+		}
+		for j := 0; j < i; j++ {
+			if headers[j].Index == h.Index {
+				return errorf(ErrShareMismatch, "keys %d and %d are the same share %d", j, i, h.Index)
+			}
+		}
+	}
+
+	if len(headers) < int(first.Threshold) {
+		if first.Threshold == first.Total {
+			return errorf(ErrTooFewShares, "all %d keys of the split are required, got %d", first.Total, len(headers))
+		}
+		return errorf(ErrTooFewShares, "at least %d keys of the split are required, got %d", first.Threshold, len(headers))
+	}
+	return nil
+}
+
+// checks that the shares are meant to be joined the way they are
+func checkShareFlags(flags, want uint8) error {
+	if flags&shareFlagHybrid != want&shareFlagHybrid {
+		if flags&shareFlagHybrid != 0 {
+			return errorf(ErrShareMismatch, "keys hold the key of a hybrid split, join them along with the encrypted file")
+		}
+		return errorf(ErrShareMismatch, "keys are not from a hybrid split")
+	}
+	return nil
+}
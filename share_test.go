@@ -0,0 +1,80 @@
+package bitsplit
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestShareRoundTrip(t *testing.T) {
+	id, err := newSetID(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := shareHeader{
+		Scheme:    SchemeShamir,
+		SetID:     id,
+		Index:     3,
+		Total:     5,
+		Threshold: 2,
+	}
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1} {
+		payload := randomBytes(size)
+		var b bytes.Buffer
+		w, err := newShareWriter(&b, header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.Copy(w, bytes.NewReader(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = w.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := newShareReader(&b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		want := header
+		want.Version = ShareFormatVersion
+		if r.shareHeader != want || !bytes.Equal(got, payload) {
+			t.Fatalf("size %d: got %+v, want %+v", size, r.shareHeader, want)
+		}
+	}
+}
+
+func TestJoinRejectsKeys(t *testing.T) {
+	split := func() [][]byte {
+		return splitToBuffers(t, 3, func(keys []io.Writer) error {
+			return SplitThreshold(nil, bytes.NewReader(randomBytes(100)), keys, 2)
+		})
+	}
+	a, b := split(), split()
+	damaged := append([]byte(nil), a[1]...)
+	damaged[len(damaged)/2] ^= 1
+	tests := []struct {
+		name string
+		keys [][]byte
+	}{
+		{"different splits", [][]byte{a[0], b[1]}},
+		{"repeated key", [][]byte{a[0], a[0]}},
+		{"too few keys", [][]byte{a[0]}},
+		{"damaged", [][]byte{a[0], damaged}},
+		{"truncated", [][]byte{a[0], a[1][:len(a[1])-1]}},
+		{"header only", [][]byte{a[0], a[1][:shareHeaderSize]}},
+		{"mixed with legacy", [][]byte{a[0], randomBytes(100)}},
+	}
+	for _, test := range tests {
+		err := Join(io.Discard, shareReaders(test.keys...))
+		if err == nil {
+			t.Errorf("%s: joined", test.name)
+		}
+	}
+}
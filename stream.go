@@ -0,0 +1,428 @@
+package bitsplit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// files are split and joined chunk by chunk, so memory use doesn't depend on the file size
+const chunkSize = 64 * 1024
+
+// splits everything written to it chunk by chunk, the keys get their trailers on Close
+type splitWriter struct {
+	random  RandomSource
+	s       ChunkScheme
+	k       int
+	writers []*shareWriter
+	digest  hash.Hash
+	// the chunk being filled
+	secret  []byte
+	length  int
+	buffers [][]byte
+	shares  [][]byte
+	closed  bool
+	err     error
+}
+
+// the data is split after a random salt of the digest
+func newSplitWriter(random RandomSource, keys []io.Writer, header shareHeader, s ChunkScheme) (*splitWriter, error) {
+	header.Flags |= shareFlagSalted
+	writers := make([]*shareWriter, len(keys))
+	for i, key := range keys {
+		header.Index = uint16(i + 1)
+		var err error
+		writers[i], err = newShareWriter(key, header)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	w := &splitWriter{
+		random:  random,
+		s:       s,
+		k:       int(header.Threshold),
+		writers: writers,
+		digest:  sha256.New(),
+		secret:  make([]byte, chunkSize),
+		buffers: make([][]byte, len(keys)),
+		shares:  make([][]byte, len(keys)),
+	}
+	for i := range w.buffers {
+		w.buffers[i] = make([]byte, chunkSize)
+	}
+	err := readRandom(random, w.secret[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+	w.length = saltSize
+	return w, nil
+}
+
+// splits the chunk filled so far and writes the shares to the keys
+func (w *splitWriter) flush() error {
+	n := w.length
+	if n == 0 {
+		return nil
+	}
+	w.length = 0
+	w.digest.Write(w.secret[:n])
+	for i := range w.shares {
+		w.shares[i] = w.buffers[i][:n]
+	}
+	err := w.s.Split(w.random, w.secret[:n], w.shares, w.k)
+	if err != nil {
+		return err
+	}
+	for i, key := range w.writers {
+		_, err := key.Write(w.shares[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *splitWriter) check() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.closed {
+		return fmt.Errorf("write to closed split writer")
+	}
+	return nil
+}
+
+func (w *splitWriter) Write(p []byte) (int, error) {
+	err := w.check()
+	if err != nil {
+		return 0, err
+	}
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.secret[w.length:], p)
+		w.length += n
+		written += n
+		p = p[n:]
+		if w.length == chunkSize {
+			w.err = w.flush()
+			if w.err != nil {
+				return written, w.err
+			}
+		}
+	}
+	return written, nil
+}
+
+// reads the file straight into the chunk, io.Copy uses it
+func (w *splitWriter) ReadFrom(file io.Reader) (int64, error) {
+	err := w.check()
+	if err != nil {
+		return 0, err
+	}
+	total := int64(0)
+	for {
+		n, err := io.ReadFull(file, w.secret[w.length:])
+		w.length += n
+		total += int64(n)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			// the keys would be incomplete, so they aren't finished by Close
+			w.err = IOError{"while reading file contents", err}
+			return total, w.err
+		}
+		if w.length == chunkSize {
+			w.err = w.flush()
+			if w.err != nil {
+				return total, w.err
+			}
+		}
+		if err != nil {
+			return total, nil
+		}
+	}
+}
+
+// splits the rest of the data and finishes the keys, the keys themselves are not closed
+func (w *splitWriter) Close() error {
+	if w.closed || w.err != nil {
+		return w.err
+	}
+	w.closed = true
+	w.err = w.flush()
+	if w.err != nil {
+		return w.err
+	}
+	digest := w.digest.Sum(nil)
+	for _, key := range w.writers {
+		key.digest = digest
+		w.err = key.Close()
+		if w.err != nil {
+			return w.err
+		}
+	}
+	return nil
+}
+
+// the payloads are written by the scheme, the salt of the digest goes before the file like for chunk schemes
+func splitStreamScheme(s StreamScheme, random RandomSource, file io.Reader, keys []io.Writer, header shareHeader) error {
+	header.Flags |= shareFlagSalted
+	writers := make([]*shareWriter, len(keys))
+	payloads := make([]io.Writer, len(keys))
+	for i, key := range keys {
+		header.Index = uint16(i + 1)
+		var err error
+		writers[i], err = newShareWriter(key, header)
+		if err != nil {
+			return err
+		}
+		payloads[i] = writers[i]
+	}
+
+	salt := make([]byte, saltSize)
+	err := readRandom(random, salt)
+	if err != nil {
+		return err
+	}
+	digest := sha256.New()
+	secret := io.TeeReader(io.MultiReader(bytes.NewReader(salt), file), digest)
+	err = s.SplitStream(random, secret, payloads, int(header.Threshold))
+	if err != nil {
+		return err
+	}
+	for _, w := range writers {
+		w.digest = digest.Sum(nil)
+		err := w.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// joins the keys as it is read, the goroutine joining them is started on the first Read
+type JoinReader struct {
+	keys   []io.Reader
+	pr     *io.PipeReader
+	joined chan struct{}
+	closed bool
+}
+
+func (r *JoinReader) Read(p []byte) (int, error) {
+	if r.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if r.pr == nil {
+		pr, pw := io.Pipe()
+		r.pr = pr
+		r.joined = make(chan struct{})
+		go func() {
+			pw.CloseWithError(Join(pw, r.keys))
+			close(r.joined)
+		}()
+	}
+	return r.pr.Read(p)
+}
+
+// stops joining and waits for the goroutine, the keys are not read after it returns.
+// The keys themselves are not closed
+func (r *JoinReader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	if r.pr != nil {
+		r.pr.Close()
+		<-r.joined
+	}
+	return nil
+}
+
+// joins keys of the given scheme, or of any scheme if it is zero. The keys must have the given flags
+func joinStream(file io.Writer, keys []io.Reader, scheme SchemeID, flags uint8) error {
+	report, err := joinShares(file, keys, scheme, flags)
+	report.warn()
+	return err
+}
+
+// what joining found out about the keys
+type JoinReport struct {
+	// indices of the shares found to be altered, if there are more of them than required
+	Altered []uint16
+	// the number of bytes corrected with the parity of every key, in the order of the keys
+	Corrected []int
+}
+
+func newJoinReport(readers []*shareReader, altered []uint16) JoinReport {
+	report := JoinReport{Altered: altered, Corrected: make([]int, len(readers))}
+	for i, r := range readers {
+		report.Corrected[i] = r.corrected
+	}
+	return report
+}
+
+func (report JoinReport) warn() {
+	if len(report.Altered) > 0 {
+		warnLog.Printf("shares %v don't fit the others, they were altered or damaged and are left out", report.Altered)
+	}
+	for i, n := range report.Corrected {
+		if n > 0 {
+			warnLog.Printf("key %d: %d damaged bytes were corrected with its parity", i, n)
+		}
+	}
+}
+
+// the joined data is checked against the digest in the keys once it is written
+func joinShares(file io.Writer, keys []io.Reader, scheme SchemeID, flags uint8) (JoinReport, error) {
+	readers := make([]*shareReader, len(keys))
+	legacy := 0
+	for i, key := range keys {
+		var err error
+		readers[i], err = newShareReader(key)
+		if err != nil {
+			return JoinReport{}, fmt.Errorf("key %d: %w", i, err)
+		}
+		if readers[i].legacy {
+			legacy++
+		}
+	}
+	if scheme == 0 && flags == 0 && legacy == len(keys) {
+		warnLog.Println("key files have no header, they are summed up as is")
+		return JoinReport{}, joinLegacy(file, readers)
+	}
+	for i, r := range readers {
+		if r.legacy {
+			return JoinReport{}, errorf(ErrShareMismatch, "key %d: not a share file", i)
+		}
+	}
+
+	headers := make([]shareHeader, len(readers))
+	for i, r := range readers {
+		headers[i] = r.shareHeader
+	}
+	err := checkShareSet(headers)
+	if err != nil {
+		return JoinReport{}, err
+	}
+	if scheme != 0 && headers[0].Scheme != scheme {
+		return JoinReport{}, errorf(ErrShareMismatch, "keys are split with %s, not %s", headers[0].Scheme, scheme)
+	}
+	if headers[0].Scheme == SchemePolicy {
+		return JoinReport{}, errorf(ErrShareMismatch, "keys are split by a policy, join them by the policy along with the encrypted file")
+	}
+	err = checkShareFlags(headers[0].Flags, flags)
+	if err != nil {
+		return JoinReport{}, err
+	}
+
+	digest := sha256.New()
+	var out io.Writer = io.MultiWriter(file, digest)
+	salted := headers[0].Flags&shareFlagSalted != 0
+	if salted {
+		out = &unsaltWriter{w: out, digest: digest, salt: saltSize}
+	}
+	s, err := schemeByID(headers[0].Scheme)
+	if err != nil {
+		return JoinReport{}, err
+	}
+	var bad []uint16
+	switch s := s.(type) {
+	case StreamScheme:
+		err = joinStreamScheme(s, out, readers, headers)
+	case ChunkScheme:
+		if s.ID() == SchemeShamir && len(headers) > int(headers[0].Threshold) {
+			bad, err = joinRobust(out, readers, headers, 0)
+		} else {
+			err = combineStream(s, out, readers, headers)
+		}
+	}
+	report := newJoinReport(readers, bad)
+	if err != nil {
+		return report, err
+	}
+	if salted && out.(*unsaltWriter).salt > 0 {
+		return report, errorf(ErrShareDamaged, "share is truncated, the salt is incomplete")
+	}
+	return report, checkDigest(digest.Sum(nil), readers)
+}
+
+func joinStreamScheme(s StreamScheme, file io.Writer, readers []*shareReader, headers []shareHeader) error {
+	payloads := make([]io.Reader, len(readers))
+	indices := make([]uint16, len(headers))
+	for i, r := range readers {
+		payloads[i] = r
+		indices[i] = headers[i].Index
+	}
+	return s.JoinStream(file, payloads, indices, int(headers[0].Threshold))
+}
+
+func combineStream(s ChunkScheme, file io.Writer, readers []*shareReader, headers []shareHeader) error {
+	indices := make([]uint16, len(headers))
+	for i, h := range headers {
+		indices[i] = h.Index
+	}
+
+	secret := make([]byte, chunkSize)
+	buffers := make([][]byte, len(readers))
+	shares := make([][]byte, len(readers))
+	for i := range buffers {
+		buffers[i] = make([]byte, chunkSize)
+	}
+	for {
+		length := 0
+		for i, r := range readers {
+			n, err := io.ReadFull(r, buffers[i])
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return fmt.Errorf("key %d: %w", i, err)
+			}
+			if i == 0 {
+				length = n
+			} else if n != length {
+				return errorf(ErrShareMismatch, "key %d has different length than key 0", i)
+			}
+			shares[i] = buffers[i][:n]
+		}
+
+		if length > 0 {
+			s.Combine(shares, indices, secret[:length])
+			_, err := file.Write(secret[:length])
+			if err != nil {
+				return IOError{"while writing secret", err}
+			}
+		}
+		if length < chunkSize {
+			return nil
+		}
+	}
+}
+
+// key files without a header are summed up, shorter ones padded with zeros
+func joinLegacy(file io.Writer, readers []*shareReader) error {
+	buffers := make([][]byte, len(readers))
+	chunks := make([][]byte, len(readers))
+	for i := range buffers {
+		buffers[i] = make([]byte, chunkSize)
+	}
+	for {
+		longest := 0
+		for i, r := range readers {
+			n, err := io.ReadFull(r.r, buffers[i])
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return IOError{"while reading key", err}
+			}
+			chunks[i] = buffers[i][:n]
+			if n > longest {
+				longest = n
+			}
+		}
+		if longest == 0 {
+			return nil
+		}
+
+		_, err := file.Write(Sum(chunks))
+		if err != nil {
+			return IOError{"while writing sum", err}
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package bitsplit
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestSplitWriter(t *testing.T) {
+	data := randomBytes(2*chunkSize + 100)
+	// written in pieces that don't line up with the chunks
+	keys := splitToBuffers(t, 3, func(keys []io.Writer) error {
+		w := NewSplitWriter(keys...)
+		for rest := data; len(rest) > 0; {
+			n := 1000
+			if n > len(rest) {
+				n = len(rest)
+			}
+			_, err := w.Write(rest[:n])
+			if err != nil {
+				return err
+			}
+			rest = rest[n:]
+		}
+		return w.Close()
+	})
+	// and copied with ReadFrom
+	copied := splitToBuffers(t, 3, func(keys []io.Writer) error {
+		w := NewSplitWriter(keys...)
+		_, err := io.Copy(w, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		return w.Close()
+	})
+
+	for _, keys := range [][][]byte{keys, copied} {
+		var out bytes.Buffer
+		err := Join(&out, shareReaders(keys...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out.Bytes(), data) {
+			t.Fatal("joined data differs")
+		}
+	}
+}
+
+func TestSplitWriterErrors(t *testing.T) {
+	var key bytes.Buffer
+	w := NewSplitWriter(&key)
+	if _, err := w.Write([]byte{1}); !errors.Is(err, ErrTooFewShares) {
+		t.Fatalf("write to 1 key: %v", err)
+	}
+	if err := w.Close(); !errors.Is(err, ErrTooFewShares) {
+		t.Fatalf("close with 1 key: %v", err)
+	}
+
+	w = NewSplitWriter(&key, &key)
+	err := w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte{1}); err == nil {
+		t.Fatal("wrote to a closed split writer")
+	}
+
+	// a failed read leaves the keys unfinished
+	var a, b bytes.Buffer
+	w = NewSplitWriter(&a, &b)
+	_, err = io.Copy(w, io.MultiReader(bytes.NewReader(randomBytes(100)), failingReader{}))
+	var ioErr IOError
+	if !errors.As(err, &ioErr) {
+		t.Fatalf("copy: got %v, want the read error", err)
+	}
+	if err := w.Close(); err != ioErr {
+		t.Fatalf("close after a failed read: %v", err)
+	}
+	if Join(io.Discard, shareReaders(a.Bytes(), b.Bytes())) == nil {
+		t.Fatal("joined keys of a failed read")
+	}
+}
+
+func TestJoinReader(t *testing.T) {
+	data := randomBytes(2*chunkSize + 100)
+	keys := splitToBuffers(t, 3, func(keys []io.Writer) error {
+		return SplitThreshold(nil, bytes.NewReader(data), keys, 2)
+	})
+	joined, err := ioutil.ReadAll(NewJoinReader(shareReaders(keys[1:]...)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(joined, data) {
+		t.Fatal("joined data differs")
+	}
+
+	_, err = ioutil.ReadAll(NewJoinReader(shareReaders(keys[0], alterPayload(keys[1], 7))...))
+	if !errors.Is(err, ErrReconstructionMismatch) {
+		t.Fatalf("got %v, want ErrReconstructionMismatch", err)
+	}
+	_, err = ioutil.ReadAll(NewJoinReader(shareReaders(keys[0])...))
+	if !errors.Is(err, ErrTooFewShares) {
+		t.Fatalf("got %v, want ErrTooFewShares", err)
+	}
+}
+
+func TestJoinReaderClose(t *testing.T) {
+	data := randomBytes(4 * chunkSize)
+	keys := splitToBuffers(t, 2, func(keys []io.Writer) error {
+		return Split(bytes.NewReader(data), keys)
+	})
+	readers := shareReaders(keys...)
+	r := NewJoinReader(readers...)
+	buf := make([]byte, 100)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the goroutine is done, so the keys are left as they are
+	left := readers[0].(*bytes.Reader).Len()
+	if left == 0 {
+		t.Fatal("the keys were read till the end")
+	}
+	if _, err := r.Read(buf); err == nil {
+		t.Fatal("read after Close")
+	}
+	if readers[0].(*bytes.Reader).Len() != left {
+		t.Fatal("the keys were read after Close")
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewJoinReader(readers...).Close(); err != nil {
+		t.Fatal(err)
+	}
+}